@@ -0,0 +1,146 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// AckBatchError - the messages of a batch which could not be acked, mapped from their index in the batch to their errors.
+type AckBatchError struct {
+	Errors map[int]error
+}
+
+func (e *AckBatchError) Error() string {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	failures := make([]string, len(indexes))
+	for i, index := range indexes {
+		failures[i] = fmt.Sprintf("%d: %v", index, e.Errors[index])
+	}
+	return fmt.Sprintf("ack has failed for %d message(s): %s", len(failures), strings.Join(failures, "; "))
+}
+
+func (e *AckBatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Msg.AckSync - acks the message and waits for the broker to confirm the ack.
+func (m *Msg) AckSync(ctx context.Context) error {
+	m.stopInProgressHeartbeat()
+	if jsMsg, ok := m.msg.(jetstream.Msg); ok {
+		err := jsMsg.DoubleAck(ctx)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		return m.pmAckSync(ctx, err)
+	}
+	if msg, ok := m.msg.(*nats.Msg); ok {
+		err := msg.AckSync(nats.Context(ctx))
+		if err == nil {
+			return nil
+		}
+		return m.pmAckSync(ctx, err)
+	}
+	return errors.New("message format is not supported")
+}
+
+// pmAckSync - acks a message resent from the dead-letter station and waits for the broker to receive the ack,
+// ackErr is returned for other messages.
+func (m *Msg) pmAckSync(ctx context.Context, ackErr error) error {
+	msgToAck, err := m.pmMsgToAck()
+	if err != nil {
+		return err
+	}
+	if msgToAck == nil {
+		return ackErr
+	}
+	msgToPublish, _ := json.Marshal(msgToAck)
+	if err := m.conn.brokerConn.Publish(memphisPmAckSubject, msgToPublish); err != nil {
+		return err
+	}
+	return m.conn.brokerConn.FlushWithContext(ctx)
+}
+
+// AckBatch - acks a batch of messages without waiting for each ack, then waits once for the broker to receive all of them.
+// Messages resent from the dead-letter station are acked as well. Returns an *AckBatchError with the messages that failed.
+func AckBatch(ctx context.Context, msgs []*Msg) error {
+	errs := make(map[int]error)
+	conns := make(map[*Conn]struct{})
+	for i, msg := range msgs {
+		if err := msg.ackAsync(); err != nil {
+			errs[i] = err
+			continue
+		}
+		if msg.conn != nil {
+			conns[msg.conn] = struct{}{}
+		}
+	}
+	for conn := range conns {
+		if err := conn.brokerConn.FlushWithContext(ctx); err != nil {
+			// the acks could not be confirmed
+			for i, msg := range msgs {
+				if _, failed := errs[i]; !failed && msg.conn == conn {
+					errs[i] = err
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &AckBatchError{Errors: errs}
+	}
+	return nil
+}
+
+// ackAsync - acks the message without waiting for the broker, unlike Ack failures to publish the ack are returned.
+func (m *Msg) ackAsync() error {
+	m.stopInProgressHeartbeat()
+	var err error
+	if jsMsg, ok := m.msg.(jetstream.Msg); ok {
+		err = jsMsg.Ack()
+	} else if msg, ok := m.msg.(*nats.Msg); ok {
+		err = msg.Ack()
+	} else {
+		return errors.New("message format is not supported")
+	}
+	if err == nil {
+		return nil
+	}
+	msgToAck, pmErr := m.pmMsgToAck()
+	if pmErr != nil {
+		return pmErr
+	}
+	if msgToAck == nil {
+		return err
+	}
+	msgToPublish, _ := json.Marshal(msgToAck)
+	return m.conn.brokerConn.Publish(memphisPmAckSubject, msgToPublish)
+}
@@ -0,0 +1,55 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testDoubleAckMsg struct {
+	testAckMsg
+	err error
+}
+
+func (m *testDoubleAckMsg) DoubleAck(ctx context.Context) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.acked = true
+	return nil
+}
+
+func TestAckSync(t *testing.T) {
+	jsMsg := &testDoubleAckMsg{}
+	if err := (&Msg{msg: jsMsg}).AckSync(context.Background()); err != nil || !jsMsg.acked {
+		t.Errorf("expected a confirmed ack, got %v", err)
+	}
+
+	ackErr := errors.New("ack timeout")
+	if err := (&Msg{msg: &testDoubleAckMsg{err: ackErr}}).AckSync(context.Background()); err != ackErr {
+		t.Errorf("expected the ack error of a message which is not a dls message, got %v", err)
+	}
+}
+
+func TestAckBatch(t *testing.T) {
+	first, second := &testAckMsg{}, &testAckMsg{}
+	msgs := []*Msg{{msg: first}, {msg: "unsupported"}, {msg: second}}
+	err := AckBatch(context.Background(), msgs)
+	var batchErr *AckBatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected an AckBatchError, got %v", err)
+	}
+	if len(batchErr.Errors) != 1 || batchErr.Errors[1] == nil {
+		t.Errorf("expected only the second message to fail, got %v", batchErr.Errors)
+	}
+	if !first.acked || !second.acked {
+		t.Error("expected the other messages to be acked")
+	}
+	if batchErr.Error() != "ack has failed for 1 message(s): 1: message format is not supported" {
+		t.Errorf("unexpected error message %q", batchErr.Error())
+	}
+
+	if err := AckBatch(context.Background(), []*Msg{{msg: &testAckMsg{}}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
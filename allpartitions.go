@@ -0,0 +1,173 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type partitionBatch struct {
+	msgs []*Msg
+	err  error
+}
+
+// consumeAllPartitions - runs a fetcher per partition and passes the merged batches to the handler until the consume is stopped.
+func (c *Consumer) consumeAllPartitions(run *consumeRun, handlerFunc ConsumeHandler, batchSize int) {
+	defer c.finishConsume(run)
+	jsConsumers := c.partitionConsumers()
+	partitions := make([]int, 0, len(jsConsumers))
+	for partitionNumber := range jsConsumers {
+		partitions = append(partitions, partitionNumber)
+	}
+	merger := newPartitionMerger(partitions)
+	for _, partitionNumber := range partitions {
+		go c.partitionFetcher(run.ctx, partitionNumber, batchSize, merger)
+	}
+	c.setDlsHandler(run, handlerFunc)
+
+	for {
+		select {
+		case <-merger.ready:
+			msgs, err := merger.take()
+			if len(msgs) > 0 {
+				handlerFunc(msgs, nil, run.handlerCtx)
+			}
+			if err != nil {
+				handlerFunc(nil, memphisError(err), run.handlerCtx)
+			}
+		case <-run.ctx.Done():
+			return
+		}
+	}
+}
+
+// partitionFetcher - fetches batches from a partition, right away after a full batch and every pull interval otherwise.
+func (c *Consumer) partitionFetcher(ctx context.Context, partitionNumber int, batchSize int, merger *partitionMerger) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+
+		msgs, err := c.fetchFromPartition(ctx, partitionNumber, batchSize)
+		if len(msgs) > 0 || err != nil {
+			merger.offer(partitionNumber, partitionBatch{msgs: msgs, err: err})
+			if !merger.waitTaken(ctx, partitionNumber) {
+				return
+			}
+		}
+
+		if len(msgs) == batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(c.PullInterval)
+		}
+	}
+}
+
+// partitionMerger - holds at most one fetched batch per partition. A fetcher fetches its next batch only once its
+// previous one was taken, and all the held batches are taken together, so each handler call gets at most one batch
+// of every partition and a hot partition can not starve the others.
+type partitionMerger struct {
+	mu      sync.Mutex
+	ready   chan struct{}
+	batches map[int]partitionBatch
+	taken   map[int]chan struct{}
+}
+
+func newPartitionMerger(partitions []int) *partitionMerger {
+	m := &partitionMerger{
+		ready:   make(chan struct{}, 1),
+		batches: make(map[int]partitionBatch, len(partitions)),
+		taken:   make(map[int]chan struct{}, len(partitions)),
+	}
+	for _, partitionNumber := range partitions {
+		m.taken[partitionNumber] = make(chan struct{}, 1)
+	}
+	return m
+}
+
+// offer - holds the batch of a partition until it is taken.
+func (m *partitionMerger) offer(partitionNumber int, batch partitionBatch) {
+	m.mu.Lock()
+	m.batches[partitionNumber] = batch
+	m.mu.Unlock()
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+// waitTaken - waits until the batch of a partition was taken, returns false when the context is done first.
+func (m *partitionMerger) waitTaken(ctx context.Context, partitionNumber int) bool {
+	select {
+	case <-m.taken[partitionNumber]:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// take - takes the held batches in partition order, merging their messages and joining their errors.
+func (m *partitionMerger) take() ([]*Msg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	partitions := make([]int, 0, len(m.batches))
+	for partitionNumber := range m.batches {
+		partitions = append(partitions, partitionNumber)
+	}
+	sort.Ints(partitions)
+
+	var msgs []*Msg
+	var errs []error
+	for _, partitionNumber := range partitions {
+		batch := m.batches[partitionNumber]
+		delete(m.batches, partitionNumber)
+		msgs = append(msgs, batch.msgs...)
+		if batch.err != nil {
+			errs = append(errs, batch.err)
+		}
+		m.taken[partitionNumber] <- struct{}{}
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// ConsumeAllPartitions - makes Consume fetch from all the partitions of the station concurrently, batches fetched at the
+// same time are merged into one handler call.
+func ConsumeAllPartitions() ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		opts.AllPartitions = true
+		return nil
+	}
+}
+
+// PartitionBatchSize - the batch size of each partition fetch when consuming all partitions, defaults to the consumer batch size.
+func PartitionBatchSize(batchSize int) ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		if batchSize > maxBatchSize || batchSize < 1 {
+			return errors.New("partition batch size can not be greater than " + strconv.Itoa(maxBatchSize) + " or less than 1")
+		}
+		opts.PartitionBatchSize = batchSize
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestConsumeAllPartitions(t *testing.T) {
+	partitions := map[int]*testJsConsumer{}
+	jsConsumers := map[int]jetstream.Consumer{}
+	for p := 1; p <= 3; p++ {
+		partitions[p] = &testJsConsumer{}
+		jsConsumers[p] = partitions[p]
+	}
+	// a hot partition
+	for i := 0; i < 20; i++ {
+		partitions[1].queue("hot")
+	}
+	partitions[2].queue("a", "b")
+	partitions[3].queue("c")
+
+	c := newTestConsumer(nil)
+	c.jsConsumers = jsConsumers
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	done := make(chan struct{})
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		hot := 0
+		for _, msg := range msgs {
+			received[string(msg.Data())]++
+			if string(msg.Data()) == "hot" {
+				hot++
+			}
+		}
+		if hot > 4 {
+			t.Errorf("expected at most one batch of the hot partition per call, got %v messages", hot)
+		}
+		if received["hot"] == 20 && received["a"]+received["b"]+received["c"] == 3 {
+			close(done)
+		}
+	}, ConsumeAllPartitions(), PartitionBatchSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		mu.Lock()
+		t.Errorf("expected all the partitions to be consumed, got %v", received)
+		mu.Unlock()
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := PartitionBatchSize(0)(&ConsumingOpts{}); err == nil {
+		t.Error("expected error for zero partition batch size")
+	}
+}
+
+func TestPartitionMerger(t *testing.T) {
+	m := newPartitionMerger([]int{1, 2, 3})
+	errA, errB := errors.New("a failed"), errors.New("b failed")
+	m.offer(3, partitionBatch{err: errB})
+	m.offer(2, partitionBatch{msgs: []*Msg{{msg: &testJsMsg{data: []byte("2")}}}})
+	m.offer(1, partitionBatch{msgs: []*Msg{{msg: &testJsMsg{data: []byte("1")}}}, err: errA})
+
+	msgs, err := m.take()
+	if len(msgs) != 2 || string(msgs[0].Data()) != "1" || string(msgs[1].Data()) != "2" {
+		t.Errorf("expected the batches to be merged in partition order, got %v", msgs)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("expected the errors of all the partitions, got %v", err)
+	}
+	for p := 1; p <= 3; p++ {
+		if !m.waitTaken(context.Background(), p) {
+			t.Errorf("expected the batch of partition %v to be taken", p)
+		}
+	}
+
+	// a partition offering again is held until the next take
+	m.offer(1, partitionBatch{msgs: []*Msg{{msg: &testJsMsg{data: []byte("1")}}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if m.waitTaken(ctx, 1) {
+		t.Error("expected the batch not to be taken yet")
+	}
+	if msgs, err := m.take(); len(msgs) != 1 || err != nil {
+		t.Errorf("expected one batch, got %v %v", msgs, err)
+	}
+	if msgs, err := m.take(); len(msgs) != 0 || err != nil {
+		t.Errorf("expected nothing to take, got %v %v", msgs, err)
+	}
+}
@@ -0,0 +1,198 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec - encodes typed messages into their wire format and decodes them back.
+type Codec[T any] interface {
+	Encode(T) ([]byte, error)
+	Decode([]byte) (T, error)
+}
+
+// JSONCodec - encodes messages as JSON, fits stations without a schema or with a json schema.
+type JSONCodec[T any] struct{}
+
+// NewJSONCodec - creates a json codec.
+func NewJSONCodec[T any]() JSONCodec[T] {
+	return JSONCodec[T]{}
+}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return b, nil
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, memphisError(errors.New("Bad JSON format - " + err.Error()))
+	}
+	return v, nil
+}
+
+// ProtoCodec - encodes messages as protobuf, T should be a pointer to a generated protobuf message.
+type ProtoCodec[T proto.Message] struct{}
+
+// NewProtoCodec - creates a protobuf codec.
+func NewProtoCodec[T proto.Message]() ProtoCodec[T] {
+	return ProtoCodec[T]{}
+}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	b, err := proto.Marshal(v)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return b, nil
+}
+
+func (ProtoCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	msgType := reflect.TypeOf(v)
+	if msgType == nil || msgType.Kind() != reflect.Pointer {
+		return v, memphisError(errors.New("protobuf codec type has to be a pointer to a message"))
+	}
+	v = reflect.New(msgType.Elem()).Interface().(T)
+	if err := proto.Unmarshal(data, v); err != nil {
+		return v, memphisError(errors.New("invalid message format, expecting protobuf"))
+	}
+	return v, nil
+}
+
+// AvroCodec - encodes messages according to an avro schema, using the wire format avro stations expect.
+type AvroCodec[T any] struct {
+	schema avro.Schema
+}
+
+// NewAvroCodec - creates an avro codec from the avro schema content.
+func NewAvroCodec[T any](schemaContent string) (*AvroCodec[T], error) {
+	sch, err := avro.Parse(schemaContent)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return &AvroCodec[T]{schema: sch}, nil
+}
+
+func (ac *AvroCodec[T]) Encode(v T) ([]byte, error) {
+	avroBytes, err := avro.Marshal(ac.schema, v)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	var message interface{}
+	if err := avro.Unmarshal(ac.schema, avroBytes, &message); err != nil {
+		return nil, memphisError(err)
+	}
+	b, err := json.Marshal(message)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return b, nil
+}
+
+func (ac *AvroCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	var message interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return v, memphisError(errors.New("Bad Avro format - " + err.Error()))
+	}
+	avroBytes, err := avro.Marshal(ac.schema, avroNativeValue(ac.schema, message))
+	if err != nil {
+		return v, memphisError(err)
+	}
+	if err := avro.Unmarshal(ac.schema, avroBytes, &v); err != nil {
+		return v, memphisError(err)
+	}
+	return v, nil
+}
+
+// avroNativeValue - converts a json decoded value into the go types the avro encoder expects for the given schema.
+func avroNativeValue(schema avro.Schema, v any) any {
+	switch sch := schema.(type) {
+	case *avro.RecordSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		record := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			record[k] = val
+		}
+		for _, field := range sch.Fields() {
+			if val, ok := m[field.Name()]; ok {
+				record[field.Name()] = avroNativeValue(field.Type(), val)
+			}
+		}
+		return record
+	case *avro.ArraySchema:
+		items, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		arr := make([]interface{}, len(items))
+		for i, item := range items {
+			arr[i] = avroNativeValue(sch.Items(), item)
+		}
+		return arr
+	case *avro.MapSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		values := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			values[k] = avroNativeValue(sch.Values(), val)
+		}
+		return values
+	case *avro.UnionSchema:
+		if v == nil {
+			return nil
+		}
+		for _, t := range sch.Types() {
+			if t.Type() != avro.Null {
+				return avroNativeValue(t, v)
+			}
+		}
+	case *avro.RefSchema:
+		return avroNativeValue(sch.Schema(), v)
+	case *avro.PrimitiveSchema:
+		switch val := v.(type) {
+		case float64:
+			switch sch.Type() {
+			case avro.Int:
+				return int(val)
+			case avro.Long:
+				return int64(val)
+			case avro.Float:
+				return float32(val)
+			}
+		case string:
+			if sch.Type() == avro.Bytes {
+				return []byte(val)
+			}
+		}
+	}
+	return v
+}
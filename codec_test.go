@@ -0,0 +1,77 @@
+package memphis
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestMsg struct {
+	Name string `json:"name" avro:"name"`
+	Age  int    `json:"age" avro:"age"`
+}
+
+const codecTestAvroSchema = `{
+	"type": "record",
+	"name": "codecTestMsg",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"}
+	]
+}`
+
+func TestJSONCodec(t *testing.T) {
+	codec := NewJSONCodec[codecTestMsg]()
+	b, err := codec.Encode(codecTestMsg{Name: "memphis", Age: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "memphis" || v.Age != 3 {
+		t.Errorf("unexpected decoded value %+v", v)
+	}
+
+	if _, err = codec.Decode([]byte("not json")); err == nil {
+		t.Error("expected decode error for invalid json")
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	codec := NewProtoCodec[*wrapperspb.StringValue]()
+	b, err := codec.Encode(wrapperspb.String("memphis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.GetValue() != "memphis" {
+		t.Errorf("unexpected decoded value %v", v.GetValue())
+	}
+}
+
+func TestAvroCodec(t *testing.T) {
+	codec, err := NewAvroCodec[codecTestMsg](codecTestAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := codec.Encode(codecTestMsg{Name: "memphis", Age: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "memphis" || v.Age != 3 {
+		t.Errorf("unexpected decoded value %+v", v)
+	}
+
+	if _, err = codec.Decode([]byte(`{"name": 3}`)); err == nil {
+		t.Error("expected decode error for a message which does not match the schema")
+	}
+}
@@ -67,17 +67,19 @@ type TLSOpts struct {
 }
 
 type Options struct {
-	Host              string
-	Port              int
-	Username          string
-	AccountId         int
-	ConnectionToken   string
-	Reconnect         bool
-	MaxReconnect      int // MaxReconnect is the maximum number of reconnection attempts. The default value is -1 which means reconnect indefinitely.
-	ReconnectInterval time.Duration
-	Timeout           time.Duration
-	TLSOpts           TLSOpts
-	Password          string
+	Host                 string
+	Port                 int
+	Username             string
+	AccountId            int
+	ConnectionToken      string
+	Reconnect            bool
+	MaxReconnect         int // MaxReconnect is the maximum number of reconnection attempts. The default value is -1 which means reconnect indefinitely.
+	ReconnectInterval    time.Duration
+	Timeout              time.Duration
+	TLSOpts              TLSOpts
+	Password             string
+	OutboxOpts           OutboxOpts
+	ProducerInterceptors []ProducerInterceptor
 }
 
 type SdkClientsUpdate struct {
@@ -173,12 +175,18 @@ type Conn struct {
 	stationUpdatesMu    sync.RWMutex
 	stationUpdatesSubs  map[string]*stationUpdateSub
 	stationFunctionSubs map[string]*stationFunctionSub
+	stationPartitionsMu sync.RWMutex
 	stationPartitions   map[string]*PartitionsUpdate
 	sdkClientsUpdatesMu sync.RWMutex
 	clientsUpdatesSub   sdkClientsUpdateSub
 	producersMap        ProducersMap
 	consumersMap        ConsumersMap
 	prefetchedMsgs      PrefetchedMsgs
+	outbox              *outbox
+	repliesMu           sync.Mutex
+	replies             *replyInbox
+	repliesCreation     *replyInboxCreation
+	replyProducers      replyProducers
 }
 
 type PartitionsUpdate struct {
@@ -236,6 +244,11 @@ func getDefaultOptions() Options {
 		ConnectionToken: "",
 		Password:        "",
 		AccountId:       1,
+		OutboxOpts: OutboxOpts{
+			FsyncPolicy:  OutboxFsyncAlways,
+			MaxBytes:     outboxDefaultMaxBytes,
+			SegmentBytes: outboxDefaultSegmentBytes,
+		},
 	}
 }
 
@@ -326,6 +339,11 @@ func (opts Options) connect() (*Conn, error) {
 	c.stationFunctionSubs = make(map[string]*stationFunctionSub)
 	c.stationPartitions = make(map[string]*PartitionsUpdate)
 
+	if err := c.initOutbox(); err != nil {
+		c.brokerConn.Close()
+		return nil, memphisError(err)
+	}
+
 	return &c, nil
 }
 
@@ -384,6 +402,7 @@ func (c *Conn) startConn() error {
 		ReconnectWait:        opts.ReconnectInterval,
 		Timeout:              opts.Timeout,
 		DisconnectedErrCB:    disconnectedError,
+		ReconnectedCB:        c.reconnectedHandler,
 		Name:                 c.ConnId + "::" + opts.Username,
 		ClosedCB:             DefaultErrHandler,
 		RetryOnFailedConnect: false,
@@ -441,7 +460,17 @@ func (c *Conn) startConn() error {
 }
 
 func (c *Conn) Close() {
+	c.repliesMu.Lock()
+	if c.replies != nil {
+		c.replies.close()
+		c.replies = nil
+	}
+	c.repliesMu.Unlock()
+	c.replyProducers.destroy()
 	c.brokerConn.Close()
+	if c.outbox != nil {
+		c.outbox.close()
+	}
 	c.setProducersMap(nil)
 	c.setConsumersMap(nil)
 }
@@ -544,6 +573,42 @@ func AccountId(accountId int) Option {
 	}
 }
 
+// Outbox - directory of a local outbox, messages produced while the broker is unreachable or whose ack failed are stored
+// in it and replayed in order once the connection returns. Disabled by default.
+func Outbox(dir string) Option {
+	return func(o *Options) error {
+		o.OutboxOpts.Dir = dir
+		return nil
+	}
+}
+
+// OutboxFsync - when messages written to the outbox are flushed to disk, default is OutboxFsyncAlways.
+func OutboxFsync(policy OutboxFsyncPolicy) Option {
+	return func(o *Options) error {
+		o.OutboxOpts.FsyncPolicy = policy
+		return nil
+	}
+}
+
+// OutboxMaxBytes - max size of the outbox, once reached produce returns ErrOutboxFull. default is 1GB.
+func OutboxMaxBytes(maxBytes int64) Option {
+	return func(o *Options) error {
+		if maxBytes < 1 {
+			return errors.New("outbox max bytes has to be a positive number")
+		}
+		o.OutboxOpts.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+// DefaultProducerInterceptors - interceptors added to every producer of the connection, before the producer's own interceptors.
+func DefaultProducerInterceptors(interceptors ...ProducerInterceptor) Option {
+	return func(o *Options) error {
+		o.ProducerInterceptors = append(o.ProducerInterceptors, interceptors...)
+		return nil
+	}
+}
+
 // TimeoutRetry - number of retries in case of timeout. default is 5.
 func TimeoutRetry(retries int) RequestOpt {
 	return func(opts *RequestOpts) error {
@@ -972,21 +1037,40 @@ func init() {
 	}
 }
 
+// stationPartitionsList - the partitions of a station, empty for stations without partitions.
+// Consumers update them when they are recovered, while producers read them on every produce.
+func (c *Conn) stationPartitionsList(stationName string) []int {
+	c.stationPartitionsMu.RLock()
+	defer c.stationPartitionsMu.RUnlock()
+	if pu := c.stationPartitions[stationName]; pu != nil {
+		return pu.PartitionsList
+	}
+	return nil
+}
+
+func (c *Conn) setStationPartitions(stationName string, pu *PartitionsUpdate) {
+	c.stationPartitionsMu.Lock()
+	defer c.stationPartitionsMu.Unlock()
+	c.stationPartitions[stationName] = pu
+}
+
 func (c *Conn) GetPartitionFromKey(key string, stationName string) (int, error) {
 	mur3 := murmur3.New32WithSeed(SEED)
 	_, err := mur3.Write([]byte(key))
 	if err != nil {
 		return -1, err
 	}
-	PartitionIndex := int(mur3.Sum32()) % len(c.stationPartitions[stationName].PartitionsList)
-	return c.stationPartitions[stationName].PartitionsList[PartitionIndex], nil
+	partitions := c.stationPartitionsList(stationName)
+	PartitionIndex := int(mur3.Sum32()) % len(partitions)
+	return partitions[PartitionIndex], nil
 }
 
 func (c *Conn) ValidatePartitionNumber(partitionNumber int, stationName string) error {
-	if partitionNumber < 0 || partitionNumber > len(c.stationPartitions[stationName].PartitionsList) {
+	partitions := c.stationPartitionsList(stationName)
+	if partitionNumber < 0 || partitionNumber > len(partitions) {
 		return errors.New("Partition number is out of range")
 	}
-	for _, partition := range c.stationPartitions[stationName].PartitionsList {
+	for _, partition := range partitions {
 		if partition == partitionNumber {
 			return nil
 		}
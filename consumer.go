@@ -23,8 +23,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hamba/avro/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -59,10 +61,9 @@ type Consumer struct {
 	conn                     *Conn
 	stationName              string
 	jsConsumers              map[int]jetstream.Consumer
+	jsConsumersMu            sync.RWMutex
 	pingInterval             time.Duration
-	subscriptionActive       bool
-	consumeActive            bool
-	consumeQuit              chan struct{}
+	subscriptionActive       atomic.Bool
 	pingQuit                 chan struct{}
 	errHandler               ConsumerErrHandler
 	StartConsumeFromSequence uint64
@@ -70,10 +71,28 @@ type Consumer struct {
 	context                  context.Context
 	realName                 string
 	dlsCurrentIndex          int
-	dlsHandlerFunc           ConsumeHandler
 	dlsMsgs                  []*Msg
 	dlsMsgsMutex             sync.RWMutex
+	dlsAttemptsMu            sync.Mutex
+	dlsAttemptsCount         map[int]int
 	PartitionGenerator       *RoundRobinProducerConsumerGenerator
+	deadLetterOnDecodeErr    bool
+	expiredPolicy            ExpiredPolicy
+	stats                    consumerStats
+	consumeMode              ConsumeMode
+	maxOutstandingMsgs       int
+	maxOutstandingBytes      int
+	idleHeartbeat            time.Duration
+	streamHandlerMu          sync.Mutex
+	lifecycleMu              sync.Mutex
+	state                    ConsumerState
+	run                      *consumeRun
+	destroying               bool
+	destroyMu                sync.Mutex
+	listenerRemoved          bool
+	backgroundStopped        bool
+	lagQuit                  chan struct{}
+	recovery                 *consumerRecovery
 }
 
 // Msg - a received message, can be acked.
@@ -83,6 +102,8 @@ type Msg struct {
 	cgName              string
 	internalStationName string
 	partition           int
+	heartbeatMu         sync.Mutex
+	heartbeat           *inProgressHeartbeat
 }
 
 type PMsgToAck struct {
@@ -128,6 +149,18 @@ func (m *Msg) DataDeserialized() (any, error) {
 		return nil, errors.New("Message format is not supported")
 	}
 
+	if sd.schemaType == "avro" && m.isAvroBinary() {
+		body, err := sd.avroBinaryBody(msgBytes)
+		if err != nil {
+			return nil, memphisError(errors.New("Deserialization has been failed since the message format does not align with the currently attached schema: " + err.Error()))
+		}
+		var avroData map[string]any
+		if err := avro.Unmarshal(sd.avroSchema, body, &avroData); err != nil {
+			return nil, memphisError(errors.New("Bad Avro format - " + err.Error()))
+		}
+		return avroData, nil
+	}
+
 	_, err = sd.validateMsg(msgBytes)
 	if err != nil {
 		return nil, memphisError(errors.New("Deserialization has been failed since the message format does not align with the currently attached schema: " + err.Error()))
@@ -171,6 +204,67 @@ func (m *Msg) DataDeserialized() (any, error) {
 	}
 }
 
+// Msg.DataDeserializedInto - deserialize message's data into v, a struct with avro tags for avro stations,
+// a protobuf message for protobuf stations or any json decodable value otherwise.
+func (m *Msg) DataDeserializedInto(v any) error {
+	sd, err := m.conn.getSchemaDetails(m.internalStationName)
+	if err != nil {
+		return memphisError(errors.New("Schema validation has failed: " + err.Error()))
+	}
+	msgBytes := m.Data()
+
+	switch sd.schemaType {
+	case "avro":
+		var body []byte
+		if m.isAvroBinary() {
+			body, err = sd.avroBinaryBody(msgBytes)
+			if err != nil {
+				return memphisError(err)
+			}
+		} else {
+			var message interface{}
+			if err := json.Unmarshal(msgBytes, &message); err != nil {
+				return memphisError(errors.New("Bad Avro format - " + err.Error()))
+			}
+			body, err = avro.Marshal(sd.avroSchema, avroNativeValue(sd.avroSchema, message))
+			if err != nil {
+				return memphisError(err)
+			}
+		}
+		if err := avro.Unmarshal(sd.avroSchema, body, v); err != nil {
+			return memphisError(err)
+		}
+		return nil
+	case "protobuf":
+		pMsg, ok := v.(proto.Message)
+		if !ok {
+			return memphisError(errors.New("protobuf messages can only be deserialized into a proto.Message"))
+		}
+		if err := proto.Unmarshal(msgBytes, pMsg); err != nil {
+			return memphisError(errors.New("invalid message format, expecting protobuf"))
+		}
+		return nil
+	default:
+		if err := json.Unmarshal(msgBytes, v); err != nil {
+			return memphisError(errors.New("Bad JSON format - " + err.Error()))
+		}
+		return nil
+	}
+}
+
+func (m *Msg) natsHeaders() nats.Header {
+	if msg, ok := m.msg.(*nats.Msg); ok {
+		return msg.Header
+	} else if jsMsg, ok := m.msg.(jetstream.Msg); ok {
+		return jsMsg.Headers()
+	}
+	return nil
+}
+
+func (m *Msg) isAvroBinary() bool {
+	return m.natsHeaders().Get(avroFormatHeader) == avroFormatBinary
+}
+
 // Msg.GetSequenceNumber - get message's sequence number
 func (m *Msg) GetSequenceNumber() (uint64, error) {
 	var seq uint64
@@ -211,6 +305,7 @@ func (m *Msg) GetTimeSent() (time.Time, error) {
 
 // Msg.Ack - ack the message.
 func (m *Msg) Ack() error {
+	m.stopInProgressHeartbeat()
 	var err error
 	if msg, ok := m.msg.(*nats.Msg); ok {
 		err = msg.Ack()
@@ -220,38 +315,40 @@ func (m *Msg) Ack() error {
 		return errors.New("message format is not supported")
 	}
 	if err != nil {
-		var headers nats.Header
-		if msg, ok := m.msg.(*nats.Msg); ok {
-			headers = msg.Header
-		} else if jsMsg, ok := m.msg.(jetstream.Msg); ok {
-			headers = jsMsg.Headers()
+		msgToAck, pmErr := m.pmMsgToAck()
+		if pmErr != nil {
+			return pmErr
 		}
-		id, ok := headers["$memphis_pm_id"]
-		if !ok {
+		if msgToAck == nil {
 			return err
-		} else {
-			idNumber, err := strconv.Atoi(id[0])
-			if err != nil {
-				return err
-			}
-			cgName, ok := headers["$memphis_pm_cg_name"]
-			if !ok {
-				return err
-			} else {
-				msgToAck := PMsgToAck{
-					ID:     idNumber,
-					CgName: cgName[0],
-				}
-				msgToPublish, _ := json.Marshal(msgToAck)
-				m.conn.brokerConn.Publish(memphisPmAckSubject, msgToPublish)
-			}
 		}
+		msgToPublish, _ := json.Marshal(msgToAck)
+		m.conn.brokerConn.Publish(memphisPmAckSubject, msgToPublish)
 	}
 	return nil
 }
 
+// pmMsgToAck - the ack of a message resent from the dead-letter station, nil for other messages.
+func (m *Msg) pmMsgToAck() (*PMsgToAck, error) {
+	headers := m.natsHeaders()
+	id, ok := headers["$memphis_pm_id"]
+	if !ok {
+		return nil, nil
+	}
+	idNumber, err := strconv.Atoi(id[0])
+	if err != nil {
+		return nil, err
+	}
+	cgName, ok := headers["$memphis_pm_cg_name"]
+	if !ok {
+		return nil, nil
+	}
+	return &PMsgToAck{ID: idNumber, CgName: cgName[0]}, nil
+}
+
 // Msg.Nack - not ack for a message, meaning that the message will be redelivered again to the same consumers group without waiting to its ack wait time.
 func (m *Msg) Nack() error {
+	m.stopInProgressHeartbeat()
 	var err error
 	if _, ok := m.msg.(*nats.Msg); ok {
 		return nil
@@ -269,6 +366,7 @@ func (m *Msg) Nack() error {
 // Msg.DeadLetter - Sending the message to the dead-letter station (DLS). the broker won't resend the message again to the same consumers group and will place the message inside the dead-letter station (DLS) with the given reason.
 // The message will still be available to other consumer groups
 func (m *Msg) DeadLetter(reason string) error {
+	m.stopInProgressHeartbeat()
 	var err error
 	if _, ok := m.msg.(*nats.Msg); ok {
 		return nil
@@ -300,29 +398,27 @@ func (m *Msg) DeadLetter(reason string) error {
 	return nil
 }
 
-// Msg.GetHeaders - get headers per message
+// Msg.GetHeaders - get headers per message, keeping the first value of every key
 func (m *Msg) GetHeaders() map[string]string {
 	headers := map[string]string{}
-	var natsHeaders nats.Header
-	if msg, ok := m.msg.(*nats.Msg); ok {
-		natsHeaders = msg.Header
-	} else if jsMsg, ok := m.msg.(jetstream.Msg); ok {
-		natsHeaders = jsMsg.Headers()
-	} else {
-		return headers
-	}
-	for key, value := range natsHeaders {
-		if strings.HasPrefix(key, "$memphis") {
-			continue
+	m.Headers().Range(func(key string, values []string) bool {
+		if len(values) > 0 {
+			headers[key] = values[0]
 		}
-		headers[key] = value[0]
-	}
+		return true
+	})
 	return headers
 }
 
+// Msg.Headers - get all the header values of the message
+func (m *Msg) Headers() Headers {
+	return userHeaders(m.natsHeaders())
+}
+
 // Msg.Delay - Delay a message redelivery
 func (m *Msg) Delay(duration time.Duration) error {
-	headers := m.GetHeaders()
+	m.stopInProgressHeartbeat()
+	headers := m.natsHeaders()
 	_, pmOk := headers["$memphis_pm_id"]
 	_, cgOk := headers["$memphis_pm_cg_name"]
 	if !pmOk || !cgOk {
@@ -379,6 +475,17 @@ type ConsumerOpts struct {
 	StartConsumeFromSequence uint64
 	LastMessages             int64
 	TimeoutRetry             int
+	DeadLetterOnDecodeErr    bool
+	ExpiredPolicy            ExpiredPolicy
+	ConsumeMode              ConsumeMode
+	MaxOutstandingMsgs       int
+	MaxOutstandingBytes      int
+	IdleHeartbeat            time.Duration
+	StartConsumeFromTime     time.Time
+	LagReportInterval        time.Duration
+	LagHandler               LagHandler
+	AutoRecoverPolicy        *RetryPolicy
+	SubscriptionStateHandler SubscriptionStateHandler
 }
 
 type createConsumerResp struct {
@@ -457,8 +564,13 @@ func (opts *ConsumerOpts) createConsumer(c *Conn, options ...RequestOpt) (*Consu
 		LastMessages:             opts.LastMessages,
 		dlsMsgs:                  []*Msg{},
 		dlsCurrentIndex:          0,
-		dlsHandlerFunc:           nil,
 		realName:                 nameWithoutSuffix,
+		deadLetterOnDecodeErr:    opts.DeadLetterOnDecodeErr,
+		expiredPolicy:            opts.ExpiredPolicy,
+		consumeMode:              opts.ConsumeMode,
+		maxOutstandingMsgs:       opts.MaxOutstandingMsgs,
+		maxOutstandingBytes:      opts.MaxOutstandingBytes,
+		idleHeartbeat:            opts.IdleHeartbeat,
 	}
 
 	if consumer.StartConsumeFromSequence == 0 {
@@ -473,10 +585,18 @@ func (opts *ConsumerOpts) createConsumer(c *Conn, options ...RequestOpt) (*Consu
 		return nil, memphisError(errors.New("Consumer creation options can't contain both startConsumeFromSequence and lastMessages"))
 	}
 
+	if !opts.StartConsumeFromTime.IsZero() && (consumer.StartConsumeFromSequence > 1 || consumer.LastMessages > -1) {
+		return nil, memphisError(errors.New("Consumer creation options can't contain startConsumeFromTime with startConsumeFromSequence or lastMessages"))
+	}
+
 	if consumer.BatchSize > maxBatchSize || consumer.BatchSize < 1 {
 		return nil, memphisError(errors.New("Batch size can not be greater than " + strconv.Itoa(maxBatchSize) + " or less than 1"))
 	}
 
+	if consumer.maxOutstandingMsgs > 0 && consumer.maxOutstandingBytes > 0 {
+		return nil, memphisError(errors.New("Consumer creation options can't contain both maxOutstandingMsgs and maxOutstandingBytes"))
+	}
+
 	sn := getInternalName(consumer.stationName)
 	_, ok := c.stationUpdatesSubs[sn]
 	if !ok {
@@ -492,7 +612,6 @@ func (opts *ConsumerOpts) createConsumer(c *Conn, options ...RequestOpt) (*Consu
 		return nil, memphisError(err)
 	}
 
-	consumer.consumeQuit = make(chan struct{})
 	consumer.pingQuit = make(chan struct{}, 1)
 
 	consumer.pingInterval = consumerDefaultPingInterval
@@ -502,34 +621,38 @@ func (opts *ConsumerOpts) createConsumer(c *Conn, options ...RequestOpt) (*Consu
 		return nil, memphisError(err)
 	}
 
-	durable := getInternalName(consumer.ConsumerGroup)
+	consumer.jsConsumers, err = consumer.bindJsConsumers()
+	if err != nil {
+		return nil, memphisError(err)
+	}
 
-	if len(consumer.conn.stationPartitions[sn].PartitionsList) == 0 {
-		consumer.jsConsumers = make(map[int]jetstream.Consumer, 1)
-		jsCons, err := c.jetstreamConsumer(sn, durable)
-		if err != nil {
-			return nil, memphisError(err)
-		}
-		consumer.jsConsumers[1] = jsCons
-	} else {
-		consumer.jsConsumers = make(map[int]jetstream.Consumer, len(consumer.conn.stationPartitions[sn].PartitionsList))
-		for _, p := range consumer.conn.stationPartitions[sn].PartitionsList {
-			streamName := fmt.Sprintf("%s$%s", sn, strconv.Itoa(p))
-			jsCons, err := c.jetstreamConsumer(streamName, durable)
-			if err != nil {
-				return nil, memphisError(err)
+	if !opts.StartConsumeFromTime.IsZero() {
+		if err := consumer.startFromTime(opts.StartConsumeFromTime); err != nil {
+			// the consumer is already registered on the broker
+			if destroyErr := c.destroy(&consumer); destroyErr != nil {
+				err = errors.Join(err, destroyErr)
 			}
-			consumer.jsConsumers[p] = jsCons
+			return nil, memphisError(err)
 		}
 	}
 
-	consumer.subscriptionActive = true
+	consumer.subscriptionActive.Store(true)
 
 	go consumer.pingConsumer()
+	if opts.AutoRecoverPolicy != nil {
+		consumer.recovery = newConsumerRecovery(*opts.AutoRecoverPolicy, opts.SubscriptionStateHandler, func() error {
+			return consumer.rebind(options...)
+		})
+	}
 	err = consumer.dlsSubscriptionInit()
 	if err != nil {
 		return nil, memphisError(err)
 	}
+	// started last, so a failed creation does not leave a reporter behind
+	if opts.LagHandler != nil {
+		consumer.lagQuit = make(chan struct{})
+		go consumer.reportLag(opts.LagReportInterval, opts.LagHandler, consumer.lagQuit)
+	}
 	c.cacheConsumer(&consumer)
 
 	return &consumer, err
@@ -552,17 +675,22 @@ func (c *Consumer) callErrHandler(err error) {
 
 func (c *Consumer) pingConsumer() {
 	ticker := time.NewTicker(c.pingInterval)
-	if !c.subscriptionActive {
+	if !c.subscriptionActive.Load() {
 		log.Fatal("started ping for inactive subscription")
 	}
 
 	for {
 		select {
 		case <-ticker.C:
+			// a running stream checks the connection with heartbeats instead
+			if c.streamRunning() {
+				continue
+			}
 			var generalErr error
 			wg := sync.WaitGroup{}
-			wg.Add(len(c.jsConsumers))
-			for _, jscons := range c.jsConsumers {
+			jsConsumers := c.partitionConsumers()
+			wg.Add(len(jsConsumers))
+			for _, jscons := range jsConsumers {
 				go func(jscons jetstream.Consumer) {
 					ctx, cancelfunc := context.WithTimeout(context.Background(), JetstreamOperationTimeout*time.Second)
 					defer cancelfunc()
@@ -578,8 +706,7 @@ func (c *Consumer) pingConsumer() {
 			wg.Wait()
 			if generalErr != nil {
 				if strings.Contains(generalErr.Error(), "consumer not found") || strings.Contains(generalErr.Error(), "stream not found") {
-					c.subscriptionActive = false
-					c.callErrHandler(ConsumerErrStationUnreachable)
+					c.stationUnreachable(generalErr)
 				}
 			}
 		case <-c.pingQuit:
@@ -589,6 +716,13 @@ func (c *Consumer) pingConsumer() {
 	}
 }
 
+// streamRunning - whether a streaming consume is running.
+func (c *Consumer) streamRunning() bool {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	return c.state == ConsumerRunning && c.consumeMode == ConsumeStreaming
+}
+
 // Consumer.SetContext - set a context that will be passed to each message handler function call
 func (c *Consumer) SetContext(ctx context.Context) {
 	c.context = ctx
@@ -601,6 +735,12 @@ type ConsumeHandler func([]*Msg, error, context.Context)
 type ConsumingOpts struct {
 	ConsumerPartitionKey    string
 	ConsumerPartitionNumber int
+	Concurrency             int
+	OrderingKey             OrderingKeyFunc
+	AllPartitions           bool
+	PartitionBatchSize      int
+	RetryPolicy             *RetryPolicy
+	InProgressInterval      time.Duration
 }
 
 type ConsumingOpt func(*ConsumingOpts) error
@@ -625,11 +765,12 @@ func getDefaultConsumingOptions() ConsumingOpts {
 	return ConsumingOpts{
 		ConsumerPartitionKey:    "",
 		ConsumerPartitionNumber: -1,
+		Concurrency:             1,
 	}
 }
 
 // Consumer.Consume - start consuming messages according to the interval configured in the consumer object.
-// When a batch is consumed the handlerFunc will be called.
+// When a batch is consumed the handlerFunc will be called. Returns an error in case the consumer is already consuming.
 func (c *Consumer) Consume(handlerFunc ConsumeHandler, opts ...ConsumingOpt) error {
 
 	defaultOpts := getDefaultConsumingOptions()
@@ -642,18 +783,55 @@ func (c *Consumer) Consume(handlerFunc ConsumeHandler, opts ...ConsumingOpt) err
 		}
 	}
 
+	run, err := c.startConsume()
+	if err != nil {
+		return err
+	}
+
+	if defaultOpts.Concurrency > 1 {
+		if defaultOpts.InProgressInterval > 0 {
+			// the heartbeat starts once a message is fetched, it may wait for a worker or behind its ordering key
+			run.pool = newWorkerPool(stoppingInProgressHeartbeat(handlerFunc), defaultOpts.Concurrency, defaultOpts.OrderingKey)
+			handlerFunc = c.startingInProgressHeartbeat(run.pool.consumeHandler(), defaultOpts.InProgressInterval)
+		} else {
+			run.pool = newWorkerPool(handlerFunc, defaultOpts.Concurrency, defaultOpts.OrderingKey)
+			handlerFunc = run.pool.consumeHandler()
+		}
+	} else if defaultOpts.InProgressInterval > 0 {
+		handlerFunc = c.withInProgressHeartbeat(handlerFunc, defaultOpts.InProgressInterval)
+	}
+
+	if c.consumeMode == ConsumeStreaming {
+		if err := c.consumeStreaming(run, handlerFunc, defaultOpts.ConsumerPartitionKey, defaultOpts.ConsumerPartitionNumber); err != nil {
+			c.requestStop()
+			c.finishConsume(run)
+			return memphisError(err)
+		}
+		return nil
+	}
+
+	if defaultOpts.AllPartitions && len(c.partitionConsumers()) > 1 && defaultOpts.ConsumerPartitionKey == "" && defaultOpts.ConsumerPartitionNumber <= 0 {
+		batchSize := defaultOpts.PartitionBatchSize
+		if batchSize == 0 {
+			batchSize = c.BatchSize
+		}
+		go c.consumeAllPartitions(run, handlerFunc, batchSize)
+		return nil
+	}
+
 	go func(c *Consumer, partitionKey string, partitionNumber int) {
+		defer c.finishConsume(run)
 
 		msgs, err := c.fetchSubscription(partitionKey, partitionNumber)
-		handlerFunc(msgs, memphisError(err), c.context)
-		c.dlsHandlerFunc = handlerFunc
+		handlerFunc(msgs, memphisError(err), run.handlerCtx)
+		c.setDlsHandler(run, handlerFunc)
 		ticker := time.NewTicker(c.PullInterval)
 		defer ticker.Stop()
 
 		for {
 			// give first priority to quit signals
 			select {
-			case <-c.consumeQuit:
+			case <-run.ctx.Done():
 				return
 			default:
 			}
@@ -661,102 +839,131 @@ func (c *Consumer) Consume(handlerFunc ConsumeHandler, opts ...ConsumingOpt) err
 			select {
 			case <-ticker.C:
 				msgs, err := c.fetchSubscription(partitionKey, partitionNumber)
-				handlerFunc(msgs, memphisError(err), nil)
-			case <-c.consumeQuit:
+				handlerFunc(msgs, memphisError(err), run.handlerCtx)
+			case <-run.ctx.Done():
 				return
 			}
 		}
 	}(c, defaultOpts.ConsumerPartitionKey, defaultOpts.ConsumerPartitionNumber)
-	c.consumeActive = true
 	return nil
 }
 
-// StopConsume - stops the continuous consume operation.
+// StopConsume - stops the continuous consume operation without waiting for the current handler call, nor for the
+// handlers of messages in flight with Concurrency. Use Stop to wait for them.
 func (c *Consumer) StopConsume() {
-	if !c.consumeActive {
+	if c.requestStop() == nil {
 		c.callErrHandler(ConsumerErrConsumeInactive)
-		return
 	}
-	c.consumeQuit <- struct{}{}
-	c.consumeActive = false
 }
 
-func (c *Consumer) fetchSubscription(partitionKey string, partitionNum int) ([]*Msg, error) {
-	if !c.subscriptionActive {
-		return nil, memphisError(errors.New("station unreachable"))
+// fetchPartition - the partition to fetch from, partitions are picked in a round robin fashion unless a key or number is given.
+func (c *Consumer) fetchPartition(partitionKey string, partitionNum int) (int, error) {
+	if len(c.partitionConsumers()) <= 1 {
+		return 1, nil
 	}
-	wrappedMsgs := make([]*Msg, 0, c.BatchSize)
-	partitionNumber := 1
+	if partitionKey != "" && partitionNum > 0 {
+		return 0, fmt.Errorf("can not use both partition number and partition key")
+	}
+	if partitionKey != "" {
+		return c.conn.GetPartitionFromKey(partitionKey, c.stationName)
+	}
+	if partitionNum > 0 {
+		if err := c.conn.ValidatePartitionNumber(partitionNum, c.stationName); err != nil {
+			return 0, err
+		}
+		return partitionNum, nil
+	}
+	c.jsConsumersMu.RLock()
+	partitionGenerator := c.PartitionGenerator
+	c.jsConsumersMu.RUnlock()
+	return partitionGenerator.Next(), nil
+}
+
+// partitionConsumers - the jetstream consumers by partition number. The map is replaced as a whole and never modified
+// once set, so it can be read without holding the lock.
+func (c *Consumer) partitionConsumers() map[int]jetstream.Consumer {
+	c.jsConsumersMu.RLock()
+	defer c.jsConsumersMu.RUnlock()
+	return c.jsConsumers
+}
 
-	if len(c.jsConsumers) > 1 {
-		if partitionKey != "" && partitionNum > 0 {
-			return nil, memphisError(fmt.Errorf("can not use both partition number and partition key"))
+// setPartitionConsumer - replaces the jetstream consumer of a partition.
+func (c *Consumer) setPartitionConsumer(partitionNumber int, jsCons jetstream.Consumer) {
+	c.jsConsumersMu.Lock()
+	defer c.jsConsumersMu.Unlock()
+	jsConsumers := make(map[int]jetstream.Consumer, len(c.jsConsumers))
+	for p, jsc := range c.jsConsumers {
+		jsConsumers[p] = jsc
+	}
+	jsConsumers[partitionNumber] = jsCons
+	c.jsConsumers = jsConsumers
+}
+
+// bindJsConsumers - looks up the jetstream consumer of the consumer group in every partition of the station.
+func (c *Consumer) bindJsConsumers() (map[int]jetstream.Consumer, error) {
+	sn := getInternalName(c.stationName)
+	durable := getInternalName(c.ConsumerGroup)
+	partitions := c.conn.stationPartitionsList(sn)
+	if len(partitions) == 0 {
+		jsCons, err := c.conn.jetstreamConsumer(sn, durable)
+		if err != nil {
+			return nil, err
 		}
-		if partitionKey != "" {
-			partitionFromKey, err := c.conn.GetPartitionFromKey(partitionKey, c.stationName)
-			if err != nil {
-				return nil, memphisError(err)
-			}
-			partitionNumber = partitionFromKey
-		} else if partitionNum > 0 {
-			err := c.conn.ValidatePartitionNumber(partitionNum, c.stationName)
-			if err != nil {
-				return nil, memphisError(err)
-			}
-			partitionNumber = partitionNum
-		} else {
-			partitionNumber = c.PartitionGenerator.Next()
+		return map[int]jetstream.Consumer{1: jsCons}, nil
+	}
+
+	jsConsumers := make(map[int]jetstream.Consumer, len(partitions))
+	for _, p := range partitions {
+		streamName := fmt.Sprintf("%s$%s", sn, strconv.Itoa(p))
+		jsCons, err := c.conn.jetstreamConsumer(streamName, durable)
+		if err != nil {
+			return nil, err
 		}
+		jsConsumers[p] = jsCons
+	}
+	return jsConsumers, nil
+}
+
+func (c *Consumer) fetchSubscription(partitionKey string, partitionNum int) ([]*Msg, error) {
+	if !c.subscriptionActive.Load() {
+		return nil, memphisError(errors.New("station unreachable"))
+	}
+	wrappedMsgs := make([]*Msg, 0, c.BatchSize)
+	partitionNumber, err := c.fetchPartition(partitionKey, partitionNum)
+	if err != nil {
+		return nil, memphisError(err)
 	}
 
-	batch, err := c.jsConsumers[partitionNumber].Fetch(c.BatchSize, jetstream.FetchMaxWait(c.BatchMaxTimeToWait))
+	batch, err := c.partitionConsumers()[partitionNumber].Fetch(c.BatchSize, jetstream.FetchMaxWait(c.BatchMaxTimeToWait))
 	if err != nil && err != nats.ErrTimeout {
-		c.subscriptionActive = false
-		c.callErrHandler(ConsumerErrStationUnreachable)
-		c.StopConsume()
+		if !c.stationUnreachable(err) {
+			c.requestStop()
+		}
+		return nil, memphisError(err)
 	}
 	if batch.Error() != nil && batch.Error() != nats.ErrTimeout {
-		c.subscriptionActive = false
-		c.callErrHandler(ConsumerErrStationUnreachable)
-		c.StopConsume()
+		if !c.stationUnreachable(batch.Error()) {
+			c.requestStop()
+		}
 	}
-	// msgs := batch.Messages()
 	internalStationName := getInternalName(c.stationName)
 	for msg := range batch.Messages() {
 		wrappedMsgs = append(wrappedMsgs, &Msg{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, internalStationName: internalStationName, partition: partitionNumber})
 	}
-	return wrappedMsgs, nil
+	return c.deferNotDueMsgs(c.handleExpiredMsgs(wrappedMsgs)), nil
 }
 
 func (c *Consumer) fetchSubscriprionWithTimeout(partitionKey string, partitionNum int) ([]*Msg, error) {
-	if !c.subscriptionActive {
+	if !c.subscriptionActive.Load() {
 		return nil, memphisError(errors.New("station unreachable"))
 	}
 	wrappedMsgs := make([]*Msg, 0, c.BatchSize)
-	partitionNumber := 1
-
-	if len(c.jsConsumers) > 1 {
-		if partitionKey != "" && partitionNum > 0 {
-			return nil, memphisError(fmt.Errorf("can not use both partition number and partition key"))
-		}
-		if partitionKey != "" {
-			partitionFromKey, err := c.conn.GetPartitionFromKey(partitionKey, c.stationName)
-			if err != nil {
-				return nil, memphisError(err)
-			}
-			partitionNumber = partitionFromKey
-		} else if partitionNum > 0 {
-			err := c.conn.ValidatePartitionNumber(partitionNum, c.stationName)
-			if err != nil {
-				return nil, memphisError(err)
-			}
-			partitionNumber = partitionNum
-		} else {
-			partitionNumber = c.PartitionGenerator.Next()
-		}
+	partitionNumber, err := c.fetchPartition(partitionKey, partitionNum)
+	if err != nil {
+		return nil, memphisError(err)
 	}
 
-	batch, err := c.jsConsumers[partitionNumber].Fetch(c.BatchSize, jetstream.FetchMaxWait(c.BatchMaxTimeToWait))
+	batch, err := c.partitionConsumers()[partitionNumber].Fetch(c.BatchSize, jetstream.FetchMaxWait(c.BatchMaxTimeToWait))
 	if err != nil && err != nats.ErrTimeout {
 		c.callErrHandler(ConsumerErrStationUnreachable)
 		return []*Msg{}, nil
@@ -770,7 +977,43 @@ func (c *Consumer) fetchSubscriprionWithTimeout(partitionKey string, partitionNu
 	for msg := range batch.Messages() {
 		wrappedMsgs = append(wrappedMsgs, &Msg{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, internalStationName: internalStationName, partition: partitionNumber})
 	}
-	return wrappedMsgs, nil
+	return c.deferNotDueMsgs(c.handleExpiredMsgs(wrappedMsgs)), nil
+}
+
+// deferNotDueMsgs - filters out messages scheduled for a later time, they are redelivered once they are due.
+// Every deferral takes a delivery of the message, so a message on its last delivery is passed on even if it is not
+// due yet, instead of being sent to the dead-letter station without reaching the handler.
+func (c *Consumer) deferNotDueMsgs(msgs []*Msg) []*Msg {
+	now := time.Now()
+	dueMsgs := msgs[:0]
+	for _, msg := range msgs {
+		if delay := msg.deliveryDelay(now); delay > 0 && !c.lastDelivery(msg) {
+			if jsMsg, ok := msg.msg.(jetstream.Msg); ok {
+				// in case the nack fails the message is redelivered after the max ack time
+				_ = jsMsg.NakWithDelay(delay)
+				continue
+			}
+		}
+		dueMsgs = append(dueMsgs, msg)
+	}
+	return dueMsgs
+}
+
+// lastDelivery - whether the broker does not redeliver the message anymore once it is nacked.
+func (c *Consumer) lastDelivery(msg *Msg) bool {
+	return c.MaxMsgDeliveries > 0 && msg.numDelivered() >= c.MaxMsgDeliveries
+}
+
+func (m *Msg) deliveryDelay(now time.Time) time.Duration {
+	deliverAt := m.natsHeaders().Get(deliverAtHeader)
+	if deliverAt == "" {
+		return 0
+	}
+	deliverAtMillis, err := strconv.ParseInt(deliverAt, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.UnixMilli(deliverAtMillis).Sub(now)
 }
 
 // Fetch - immediately fetch a batch of messages.
@@ -790,20 +1033,12 @@ func (c *Consumer) Fetch(batchSize int, prefetch bool, opts ...ConsumingOpt) ([]
 	}
 
 	c.BatchSize = batchSize
-	var msgs []*Msg
-	if len(c.dlsMsgs) > 0 {
-		c.dlsMsgsMutex.Lock()
-		if len(c.dlsMsgs) <= batchSize {
-			msgs = c.dlsMsgs
-			c.dlsMsgs = []*Msg{}
-		} else {
-			msgs = c.dlsMsgs[:batchSize-1]
-			c.dlsMsgs = c.dlsMsgs[batchSize-1:]
-		}
-		c.dlsMsgsMutex.Unlock()
+	if msgs := c.takeDlsMsgs(batchSize); len(msgs) > 0 {
 		return msgs, nil
 	}
 
+	var msgs []*Msg
+
 	c.conn.prefetchedMsgs.lock.Lock()
 	lowerCaseStationName := getLowerCaseName(c.stationName)
 	if prefetchedMsgsForStation, ok := c.conn.prefetchedMsgs.msgs[lowerCaseStationName]; ok {
@@ -854,10 +1089,17 @@ func (c *Consumer) dlsSubscriptionInit() error {
 
 func (c *Consumer) createDlsMsgHandler() nats.MsgHandler {
 	return func(msg *nats.Msg) {
+		var dlsHandler ConsumeHandler
+		var handlerCtx context.Context
+		c.lifecycleMu.Lock()
+		if c.state == ConsumerRunning {
+			dlsHandler, handlerCtx = c.run.dlsHandler, c.run.handlerCtx
+		}
+		c.lifecycleMu.Unlock()
 		// if a consume function is active
-		if c.dlsHandlerFunc != nil {
-			dlsMsg := []*Msg{{msg: msg, conn: c.conn, cgName: c.ConsumerGroup}}
-			c.dlsHandlerFunc(dlsMsg, nil, nil)
+		if dlsHandler != nil {
+			dlsMsg := []*Msg{{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, internalStationName: getInternalName(c.stationName)}}
+			dlsHandler(dlsMsg, nil, handlerCtx)
 		} else {
 			// for fetch function
 			internalStationName := getInternalName(c.stationName)
@@ -877,6 +1119,24 @@ func (c *Consumer) createDlsMsgHandler() nats.MsgHandler {
 	}
 }
 
+// takeDlsMsgs - takes up to batchSize of the dead letter messages received while no consume function is active.
+func (c *Consumer) takeDlsMsgs(batchSize int) []*Msg {
+	c.dlsMsgsMutex.Lock()
+	defer c.dlsMsgsMutex.Unlock()
+	if len(c.dlsMsgs) == 0 {
+		return nil
+	}
+	var msgs []*Msg
+	if len(c.dlsMsgs) <= batchSize {
+		msgs = c.dlsMsgs
+		c.dlsMsgs = []*Msg{}
+	} else {
+		msgs = c.dlsMsgs[:batchSize]
+		c.dlsMsgs = c.dlsMsgs[batchSize:]
+	}
+	return msgs
+}
+
 func (c *Consumer) getDlsSubjName() string {
 	stationName := getInternalName(c.stationName)
 	consumerGroup := getInternalName(c.ConsumerGroup)
@@ -887,20 +1147,53 @@ func (c *Consumer) getDlsQueueName() string {
 	return c.getDlsSubjName()
 }
 
-// Destroy - destroy this consumer.
+// Destroy - destroy this consumer, stops consuming without waiting for the current handler call. Calling it again once
+// it was destroyed does nothing. When it fails the consumer can not consume anymore, and calling it again retries the
+// steps which have not succeeded yet.
 func (c *Consumer) Destroy(options ...RequestOpt) error {
-	if err := c.conn.removeSchemaUpdatesListener(c.stationName); err != nil {
-		return memphisError(err)
+	c.destroyMu.Lock()
+	defer c.destroyMu.Unlock()
+	c.lifecycleMu.Lock()
+	if c.state == ConsumerDestroyed {
+		c.lifecycleMu.Unlock()
+		return nil
+	}
+	if c.state == ConsumerRunning {
+		c.state = ConsumerStopping
+		c.run.cancel()
 	}
-	if c.consumeActive {
-		c.StopConsume()
+	c.destroying = true
+	c.lifecycleMu.Unlock()
+
+	if !c.listenerRemoved {
+		// the reference to the listener is released even when removing it fails, so it is never released twice
+		c.listenerRemoved = true
+		if err := c.conn.removeSchemaUpdatesListener(c.stationName); err != nil {
+			return memphisError(err)
+		}
 	}
-	if c.subscriptionActive {
-		c.pingQuit <- struct{}{}
+
+	if !c.backgroundStopped {
+		c.backgroundStopped = true
+		if c.subscriptionActive.Load() || c.recovery != nil {
+			c.pingQuit <- struct{}{}
+		}
+		if c.lagQuit != nil {
+			close(c.lagQuit)
+		}
+		if c.recovery != nil {
+			c.recovery.stop()
+		}
+		c.conn.unCacheConsumer(c)
 	}
 
-	c.conn.unCacheConsumer(c)
-	return c.conn.destroy(c, options...)
+	if err := c.conn.destroy(c, options...); err != nil {
+		return err
+	}
+	c.lifecycleMu.Lock()
+	c.state = ConsumerDestroyed
+	c.lifecycleMu.Unlock()
+	return nil
 }
 
 func (c *Consumer) getCreationSubject() string {
@@ -931,7 +1224,7 @@ func (c *Consumer) handleCreationResp(resp []byte) error {
 	err := json.Unmarshal(resp, cr)
 	if err != nil {
 		// unmarshal failed, we may be dealing with an old broker
-		c.conn.stationPartitions[sn] = &PartitionsUpdate{}
+		c.conn.setStationPartitions(sn, &PartitionsUpdate{})
 		return defaultHandleCreationResp(resp)
 	}
 
@@ -942,11 +1235,14 @@ func (c *Consumer) handleCreationResp(resp []byte) error {
 	c.conn.stationUpdatesMu.Lock()
 	sd := &c.conn.stationUpdatesSubs[sn].schemaDetails
 	sd.handleSchemaUpdateInit(cr.SchemaUpdateInit)
+	c.conn.stationUpdatesSubs[sn].storeSchema()
 	c.conn.stationUpdatesMu.Unlock()
 
-	c.conn.stationPartitions[sn] = &cr.PartitionsUpdate
+	c.conn.setStationPartitions(sn, &cr.PartitionsUpdate)
 	if len(cr.PartitionsUpdate.PartitionsList) > 0 {
+		c.jsConsumersMu.Lock()
 		c.PartitionGenerator = newRoundRobinGenerator(cr.PartitionsUpdate.PartitionsList)
+		c.jsConsumersMu.Unlock()
 	}
 
 	return nil
@@ -1051,6 +1347,22 @@ func ConsumerTimeoutRetry(timeoutRetry int) ConsumerOpt {
 	}
 }
 
+// DeadLetterOnDecodeError - send messages which typed consumers fail to validate or decode to the dead-letter station instead of the error handler.
+func DeadLetterOnDecodeError() ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.DeadLetterOnDecodeErr = true
+		return nil
+	}
+}
+
+// ConsumerExpiredPolicy - set what the consumer does with messages whose TTL has passed, default is ExpiredDrop.
+func ConsumerExpiredPolicy(policy ExpiredPolicy) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.ExpiredPolicy = policy
+		return nil
+	}
+}
+
 func (con *Conn) cacheConsumer(c *Consumer) {
 	cm := con.getConsumersMap()
 	cm.setConsumer(c)
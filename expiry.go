@@ -0,0 +1,102 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const expiresAtHeader = "$memphis_expires_at"
+
+// ExpiredPolicy - what a consumer does with messages whose TTL has passed.
+type ExpiredPolicy int
+
+const (
+	// ExpiredDrop - ack the message without passing it to the handler, the default.
+	ExpiredDrop ExpiredPolicy = iota
+	// ExpiredDeadLetter - send the message to the dead-letter station.
+	ExpiredDeadLetter
+	// ExpiredDeliver - pass the message to the handler anyway.
+	ExpiredDeliver
+)
+
+// ConsumerStats - counters of a consumer since its creation.
+type ConsumerStats struct {
+	ExpiredDropped      uint64
+	ExpiredDeadLettered uint64
+	ExpiredDelivered    uint64
+}
+
+type consumerStats struct {
+	expiredDropped      atomic.Uint64
+	expiredDeadLettered atomic.Uint64
+	expiredDelivered    atomic.Uint64
+}
+
+// Consumer.Stats - get the counters of the consumer.
+func (c *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		ExpiredDropped:      c.stats.expiredDropped.Load(),
+		ExpiredDeadLettered: c.stats.expiredDeadLettered.Load(),
+		ExpiredDelivered:    c.stats.expiredDelivered.Load(),
+	}
+}
+
+// Msg.IsExpired - whether the TTL of the message has passed.
+func (m *Msg) IsExpired() bool {
+	expiresAt, ok := m.expiresAt()
+	return ok && !time.Now().Before(expiresAt)
+}
+
+func (m *Msg) expiresAt() (time.Time, bool) {
+	expiresAt := m.natsHeaders().Get(expiresAtHeader)
+	if expiresAt == "" {
+		return time.Time{}, false
+	}
+	expiresAtMillis, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(expiresAtMillis), true
+}
+
+// handleExpiredMsgs - filters out expired messages according to the consumer expired policy.
+func (c *Consumer) handleExpiredMsgs(msgs []*Msg) []*Msg {
+	liveMsgs := msgs[:0]
+	for _, msg := range msgs {
+		if !msg.IsExpired() {
+			liveMsgs = append(liveMsgs, msg)
+			continue
+		}
+		switch c.expiredPolicy {
+		case ExpiredDeliver:
+			c.stats.expiredDelivered.Add(1)
+			liveMsgs = append(liveMsgs, msg)
+		case ExpiredDeadLetter:
+			if err := msg.DeadLetter("message has expired"); err != nil {
+				c.callErrHandler(memphisError(err))
+			}
+			c.stats.expiredDeadLettered.Add(1)
+		default:
+			if err := msg.Ack(); err != nil {
+				c.callErrHandler(memphisError(err))
+			}
+			c.stats.expiredDropped.Add(1)
+		}
+	}
+	return liveMsgs
+}
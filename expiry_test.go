@@ -0,0 +1,42 @@
+package memphis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestHandleExpiredMsgs(t *testing.T) {
+	opts := getDefaultProduceOpts()
+	if err := TTL(-time.Second)(&opts); err == nil {
+		t.Error("expected error for a negative ttl")
+	}
+
+	expiredMsg := func() *Msg {
+		expiresAt := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+		return &Msg{msg: &nats.Msg{Header: nats.Header{expiresAtHeader: []string{expiresAt}}}}
+	}
+	liveMsg := &Msg{msg: &nats.Msg{Header: nats.Header{expiresAtHeader: []string{strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)}}}}
+	if liveMsg.IsExpired() || !expiredMsg().IsExpired() {
+		t.Fatal("unexpected expiry")
+	}
+
+	c := &Consumer{expiredPolicy: ExpiredDeliver}
+	if msgs := c.handleExpiredMsgs([]*Msg{liveMsg, expiredMsg()}); len(msgs) != 2 {
+		t.Errorf("expected expired messages to be delivered, got %v messages", len(msgs))
+	}
+	if stats := c.Stats(); stats.ExpiredDelivered != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	c = &Consumer{expiredPolicy: ExpiredDrop}
+	msgs := c.handleExpiredMsgs([]*Msg{expiredMsg(), liveMsg, expiredMsg()})
+	if len(msgs) != 1 || msgs[0] != liveMsg {
+		t.Errorf("expected expired messages to be dropped, got %v messages", len(msgs))
+	}
+	if stats := c.Stats(); stats.ExpiredDropped != 2 || stats.ExpiredDelivered != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,270 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	ErrCircuitOpen = errors.New("producer circuit breaker is open")
+	ErrRateLimited = errors.New("producer rate limit exceeded")
+)
+
+// RateLimitPolicy - what a rate limited producer does with messages exceeding the rate.
+type RateLimitPolicy int
+
+const (
+	// RateLimitBlock - wait until the message can be sent, the default.
+	RateLimitBlock RateLimitPolicy = iota
+	// RateLimitDrop - drop the message and return ErrRateLimited.
+	RateLimitDrop
+)
+
+// RateLimitHandler - called when a message exceeds the producer rate limit, dropped tells whether it was dropped or delayed.
+type RateLimitHandler func(p *Producer, dropped bool)
+
+// CircuitState - state of a producer circuit breaker.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	return [...]string{"closed", "open", "half_open"}[s]
+}
+
+// CircuitStateHandler - called when the producer circuit breaker changes its state.
+type CircuitStateHandler func(p *Producer, state CircuitState)
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(msgsPerSec float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   msgsPerSec,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take - takes a token from the bucket. When blocking, the token is reserved and the time to wait for it is returned,
+// otherwise false is returned when no token is available.
+func (tb *tokenBucket) take(block bool) (time.Duration, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0, true
+	}
+	if !block {
+		return 0, false
+	}
+	tb.tokens--
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second)), true
+}
+
+type circuitBreaker struct {
+	mu            sync.Mutex
+	state         CircuitState
+	failures      int
+	threshold     int
+	openTimeout   time.Duration
+	openedAt      time.Time
+	probing       bool
+	onStateChange func(CircuitState)
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration, onStateChange func(CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		state:         CircuitClosed,
+		threshold:     threshold,
+		openTimeout:   openTimeout,
+		onStateChange: onStateChange,
+	}
+}
+
+// allow - whether a message can be sent, after the open timeout a single probe is let through in the half open state.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	switch cb.state {
+	case CircuitClosed:
+		cb.mu.Unlock()
+		return true
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.openTimeout {
+			cb.mu.Unlock()
+			return false
+		}
+		cb.probing = true
+		cb.setState(CircuitHalfOpen)
+		return true
+	default:
+		if cb.probing {
+			cb.mu.Unlock()
+			return false
+		}
+		cb.probing = true
+		cb.mu.Unlock()
+		return true
+	}
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	cb.failures = 0
+	cb.probing = false
+	if cb.state == CircuitHalfOpen {
+		cb.setState(CircuitClosed)
+		return
+	}
+	cb.mu.Unlock()
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	cb.failures++
+	cb.probing = false
+	if cb.state == CircuitHalfOpen || (cb.state == CircuitClosed && cb.failures >= cb.threshold) {
+		cb.openedAt = time.Now()
+		cb.setState(CircuitOpen)
+		return
+	}
+	cb.mu.Unlock()
+}
+
+// release - gives back a probe which was let through but not sent.
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	cb.probing = false
+	cb.mu.Unlock()
+}
+
+// setState - sets the state and unlocks the breaker before calling the state change callback.
+func (cb *circuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.mu.Unlock()
+	if cb.onStateChange != nil {
+		cb.onStateChange(state)
+	}
+}
+
+// admit - whether a message can be sent, the circuit breaker is checked first so rejected messages don't take rate limit tokens.
+func (p *Producer) admit() error {
+	if p.breaker != nil && !p.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	if err := p.waitForRateLimit(); err != nil {
+		if p.breaker != nil {
+			p.breaker.release()
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *Producer) waitForRateLimit() error {
+	if p.rateLimiter == nil {
+		return nil
+	}
+	wait, ok := p.rateLimiter.take(p.rateLimitPolicy == RateLimitBlock)
+	if !ok {
+		if p.rateLimitHandler != nil {
+			p.rateLimitHandler(p, true)
+		}
+		return ErrRateLimited
+	}
+	if wait > 0 {
+		if p.rateLimitHandler != nil {
+			p.rateLimitHandler(p, false)
+		}
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+func (p *Producer) initFlowControl(opts ProducerOpts) {
+	if opts.RateLimitMsgsPerSec > 0 {
+		p.rateLimiter = newTokenBucket(opts.RateLimitMsgsPerSec, opts.RateLimitBurst)
+		p.rateLimitPolicy = opts.RateLimitPolicy
+		p.rateLimitHandler = opts.RateLimitHandler
+	}
+	if opts.CircuitBreakerThreshold > 0 {
+		handler := opts.CircuitStateHandler
+		p.breaker = newCircuitBreaker(opts.CircuitBreakerThreshold, opts.CircuitBreakerOpenTimeout, func(state CircuitState) {
+			if handler != nil {
+				handler(p, state)
+			}
+		})
+	}
+}
+
+func (p *Producer) recordPublishResult(err error) {
+	if p.breaker == nil {
+		return
+	}
+	if err != nil {
+		p.breaker.failure()
+		return
+	}
+	p.breaker.success()
+}
+
+// watchAck - records the ack result of an async produce in the circuit breaker, stores unacked messages in the outbox
+// and passes the result to the interceptors.
+func (p *Producer) watchAck(paf jetstream.PubAckFuture, msg *nats.Msg, im *InterceptedMsg, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var ack *jetstream.PubAck
+	var err error
+	select {
+	case ack = <-paf.Ok():
+	case err = <-paf.Err():
+	case <-timer.C:
+		err = errors.New("ack timeout")
+	}
+	defer p.releaseHeaders(msg.Header)
+	p.recordPublishResult(err)
+	if err != nil && p.conn.outbox != nil {
+		if err := p.conn.storeInOutbox(msg); err != nil {
+			log.Printf("Producer %v: failed to store message in the outbox: %v", p.Name, memphisError(err))
+		}
+		return
+	}
+	p.afterAck(im, ack, err)
+}
@@ -0,0 +1,94 @@
+package memphis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := newTokenBucket(10, 2)
+	for i := 0; i < 2; i++ {
+		if _, ok := tb.take(false); !ok {
+			t.Fatal("expected a token within the burst")
+		}
+	}
+	if _, ok := tb.take(false); ok {
+		t.Error("expected the bucket to be empty")
+	}
+	wait, ok := tb.take(true)
+	if !ok || wait <= 0 || wait > 200*time.Millisecond {
+		t.Errorf("unexpected wait %v", wait)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []CircuitState
+	cb := newCircuitBreaker(2, 10*time.Millisecond, func(state CircuitState) {
+		states = append(states, state)
+	})
+
+	cb.failure()
+	if !cb.allow() {
+		t.Fatal("breaker should be closed below the threshold")
+	}
+	cb.failure()
+	if cb.allow() {
+		t.Fatal("breaker should be open after reaching the threshold")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !cb.allow() {
+		t.Fatal("breaker should let a probe through after the open timeout")
+	}
+	if cb.allow() {
+		t.Fatal("breaker should let a single probe through")
+	}
+	cb.failure()
+	if cb.allow() {
+		t.Fatal("breaker should open again after a failed probe")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cb.allow()
+	cb.success()
+	if !cb.allow() {
+		t.Fatal("breaker should be closed after a successful probe")
+	}
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(states) != len(expected) {
+		t.Fatalf("unexpected state changes %v", states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("unexpected state changes %v", states)
+		}
+	}
+}
+
+func TestProducerAdmit(t *testing.T) {
+	p := &Producer{rateLimitPolicy: RateLimitDrop, rateLimiter: newTokenBucket(0.001, 1)}
+	p.breaker = newCircuitBreaker(1, 10*time.Millisecond, nil)
+
+	p.breaker.failure()
+	for i := 0; i < 3; i++ {
+		if err := p.admit(); err != ErrCircuitOpen {
+			t.Fatalf("expected the open breaker to reject the message, got %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := p.admit(); err != nil {
+		t.Fatalf("expected the rejected messages not to take rate limit tokens, got %v", err)
+	}
+
+	// a probe which is rate limited is given back
+	p.breaker.failure()
+	time.Sleep(20 * time.Millisecond)
+	if err := p.admit(); err != ErrRateLimited {
+		t.Fatalf("expected the probe to be rate limited, got %v", err)
+	}
+	p.rateLimiter = nil
+	if err := p.admit(); err != nil {
+		t.Errorf("expected the next message to probe the breaker, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MessageHandler - handles a single message, the message is acked when nil is returned and retried according to the
+// retry policy otherwise.
+type MessageHandler func(ctx context.Context, msg *Msg) error
+
+// RetryPolicy - how messages whose handler failed are retried.
+// A failed message is redelivered after InitialBackoff, multiplied by Multiplier on every further attempt up to MaxBackoff.
+// Once MaxAttempts deliveries have failed the message is sent to the dead-letter station with the error as the reason.
+// Attempts are counted by deliveries, so the deferral of a scheduled message which is not due yet counts as an attempt.
+// Messages resent from the dead-letter station are resent by the broker on its own, once they failed MaxAttempts times
+// the consumer stops passing them to the handler and they are left in the dead-letter station.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy - retries after 1s, 2s, 4s and so on up to a minute, until the max message deliveries of the consumer are reached.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+	}
+}
+
+// backoff - the delay before the next delivery of a message which failed its attempt-th delivery.
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(rp.InitialBackoff) * math.Pow(rp.Multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && backoff > float64(rp.MaxBackoff) {
+		return rp.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// Consumer.ConsumeMessages - starts consuming like Consume, passing the messages one by one to a handler which returns an error.
+// A message is acked when the handler returns nil, and retried according to the retry policy when it returns an error or panics.
+// Fetch errors are passed to the consumer error handler.
+func (c *Consumer) ConsumeMessages(handler MessageHandler, opts ...ConsumingOpt) error {
+	consumingOpts, err := getConsumingOpts(opts)
+	if err != nil {
+		return memphisError(err)
+	}
+	policy := DefaultRetryPolicy()
+	if consumingOpts.RetryPolicy != nil {
+		policy = *consumingOpts.RetryPolicy
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = c.MaxMsgDeliveries
+	}
+
+	return c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		if err != nil {
+			c.callErrHandler(err)
+		}
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		for _, msg := range msgs {
+			c.handleMessage(ctx, handler, policy, msg)
+		}
+	}, opts...)
+}
+
+func (c *Consumer) handleMessage(ctx context.Context, handler MessageHandler, policy RetryPolicy, msg *Msg) {
+	dlsId, fromDls := msg.dlsId()
+	if fromDls && c.dlsAttempts(dlsId) >= policy.MaxAttempts {
+		return
+	}
+	if err := callMessageHandler(ctx, handler, msg); err != nil {
+		c.retryMessage(policy, msg, err)
+		return
+	}
+	if fromDls {
+		c.forgetDlsAttempts(dlsId)
+	}
+	if err := msg.Ack(); err != nil {
+		c.callErrHandler(err)
+	}
+}
+
+// callMessageHandler - calls the handler, a panic is returned as an error.
+func callMessageHandler(ctx context.Context, handler MessageHandler, msg *Msg) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("message handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+func (c *Consumer) retryMessage(policy RetryPolicy, msg *Msg, handlerErr error) {
+	if dlsId, fromDls := msg.dlsId(); fromDls {
+		// dead-letter messages can not be delayed, the broker resends them until they are acked
+		if c.addDlsAttempt(dlsId) >= policy.MaxAttempts {
+			c.callErrHandler(fmt.Errorf("dead-letter message %v has failed %v times and is left in the dead-letter station: %v", dlsId, policy.MaxAttempts, handlerErr))
+		}
+		return
+	}
+	attempt := msg.numDelivered()
+	var err error
+	if attempt >= policy.MaxAttempts {
+		err = msg.DeadLetter(handlerErr.Error())
+	} else {
+		err = msg.Delay(policy.backoff(attempt))
+	}
+	if err != nil {
+		c.callErrHandler(err)
+	}
+}
+
+// dlsId - the id of a message resent from the dead-letter station.
+func (m *Msg) dlsId() (int, bool) {
+	pmMsg, err := m.pmMsgToAck()
+	if err != nil || pmMsg == nil {
+		return 0, false
+	}
+	return pmMsg.ID, true
+}
+
+// dlsAttempts - how many times the handler has failed a message resent from the dead-letter station.
+func (c *Consumer) dlsAttempts(dlsId int) int {
+	c.dlsAttemptsMu.Lock()
+	defer c.dlsAttemptsMu.Unlock()
+	return c.dlsAttemptsCount[dlsId]
+}
+
+func (c *Consumer) addDlsAttempt(dlsId int) int {
+	c.dlsAttemptsMu.Lock()
+	defer c.dlsAttemptsMu.Unlock()
+	if c.dlsAttemptsCount == nil {
+		c.dlsAttemptsCount = make(map[int]int)
+	}
+	c.dlsAttemptsCount[dlsId]++
+	return c.dlsAttemptsCount[dlsId]
+}
+
+func (c *Consumer) forgetDlsAttempts(dlsId int) {
+	c.dlsAttemptsMu.Lock()
+	defer c.dlsAttemptsMu.Unlock()
+	delete(c.dlsAttemptsCount, dlsId)
+}
+
+// numDelivered - how many times the message was delivered, including this delivery.
+func (m *Msg) numDelivered() int {
+	if jsMsg, ok := m.msg.(jetstream.Msg); ok {
+		if meta, err := jsMsg.Metadata(); err == nil {
+			return int(meta.NumDelivered)
+		}
+	} else if msg, ok := m.msg.(*nats.Msg); ok {
+		if meta, err := msg.Metadata(); err == nil {
+			return int(meta.NumDelivered)
+		}
+	}
+	return 1
+}
+
+// HandlerRetryPolicy - the retry policy of messages whose handler failed when consuming with ConsumeMessages,
+// defaults to DefaultRetryPolicy.
+func HandlerRetryPolicy(policy RetryPolicy) ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 || policy.MaxAttempts < 0 {
+			return errors.New("retry policy values can not be negative")
+		}
+		if policy.Multiplier < 1 {
+			return errors.New("retry policy multiplier has to be at least 1")
+		}
+		opts.RetryPolicy = &policy
+		return nil
+	}
+}
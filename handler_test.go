@@ -0,0 +1,92 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testAckMsg struct {
+	testJsMsg
+	numDelivered uint64
+	acked        bool
+	nakDelay     time.Duration
+}
+
+func (m *testAckMsg) Ack() error { m.acked = true; return nil }
+func (m *testAckMsg) NakWithDelay(delay time.Duration) error {
+	m.nakDelay = delay
+	return nil
+}
+func (m *testAckMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.numDelivered}, nil
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if backoff := policy.backoff(attempt); backoff != expected {
+			t.Errorf("attempt %v: expected %v, got %v", attempt, expected, backoff)
+		}
+	}
+	if err := HandlerRetryPolicy(RetryPolicy{Multiplier: 0.5})(&ConsumingOpts{}); err == nil {
+		t.Error("expected error for a multiplier below 1")
+	}
+	if err := HandlerRetryPolicy(RetryPolicy{Multiplier: 1, MaxAttempts: -1})(&ConsumingOpts{}); err == nil {
+		t.Error("expected error for negative max attempts")
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	var errs []error
+	c := &Consumer{errHandler: func(_ *Consumer, err error) { errs = append(errs, err) }}
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 3}
+
+	okMsg := &testAckMsg{numDelivered: 1}
+	c.handleMessage(context.Background(), func(ctx context.Context, msg *Msg) error { return nil }, policy, &Msg{msg: okMsg})
+	if !okMsg.acked || okMsg.nakDelay != 0 {
+		t.Error("expected a handled message to be acked")
+	}
+
+	failedMsg := &testAckMsg{numDelivered: 2}
+	c.handleMessage(context.Background(), func(ctx context.Context, msg *Msg) error { return errors.New("failed") }, policy, &Msg{msg: failedMsg})
+	if failedMsg.acked || failedMsg.nakDelay != 3*time.Second {
+		t.Errorf("expected a failed message to be redelivered after 3s, got %v", failedMsg.nakDelay)
+	}
+
+	panickedMsg := &testAckMsg{numDelivered: 1}
+	c.handleMessage(context.Background(), func(ctx context.Context, msg *Msg) error { panic("boom") }, policy, &Msg{msg: panickedMsg})
+	if panickedMsg.acked || panickedMsg.nakDelay != time.Second {
+		t.Errorf("expected a panicked message to be redelivered after 1s, got %v", panickedMsg.nakDelay)
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestHandleDlsMessage(t *testing.T) {
+	var errs []error
+	c := &Consumer{errHandler: func(_ *Consumer, err error) { errs = append(errs, err) }}
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Multiplier: 2}
+	dlsMsg := &Msg{msg: &nats.Msg{Header: nats.Header{"$memphis_pm_id": []string{"7"}, "$memphis_pm_cg_name": []string{"cg"}}}}
+
+	calls := 0
+	handler := func(ctx context.Context, msg *Msg) error {
+		calls++
+		return errors.New("failed")
+	}
+	// every resend of the message by the broker is a delivery of its own
+	for i := 0; i < 5; i++ {
+		c.handleMessage(context.Background(), handler, policy, dlsMsg)
+	}
+	if calls != 3 {
+		t.Errorf("expected the handler to be called MaxAttempts times, got %v", calls)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected the exhausted attempts to be reported once, got %v", errs)
+	}
+}
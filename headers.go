@@ -0,0 +1,177 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const reservedHeaderPrefix = "$memphis"
+
+// Headers - message headers, a key may have multiple values.
+// The zero value is ready to use, keys starting with $memphis are reserved and can not be read or written.
+type Headers struct {
+	MsgHeaders map[string][]string
+}
+
+func validateHeaderKey(key string) error {
+	if isReservedHeaderKey(key) {
+		return memphisError(errors.New("keys in headers should not start with $memphis"))
+	}
+	return nil
+}
+
+func isReservedHeaderKey(key string) bool {
+	return strings.HasPrefix(key, reservedHeaderPrefix)
+}
+
+// Headers.New - clears the headers.
+func (hdr *Headers) New() {
+	hdr.MsgHeaders = map[string][]string{}
+}
+
+// Headers.Add - adds a value to the values of a key.
+func (hdr *Headers) Add(key, value string) error {
+	if err := validateHeaderKey(key); err != nil {
+		return err
+	}
+	if hdr.MsgHeaders == nil {
+		hdr.New()
+	}
+	hdr.MsgHeaders[key] = append(hdr.MsgHeaders[key], value)
+	return nil
+}
+
+// Headers.Set - sets the value of a key, replacing its existing values.
+func (hdr *Headers) Set(key, value string) error {
+	if err := validateHeaderKey(key); err != nil {
+		return err
+	}
+	if hdr.MsgHeaders == nil {
+		hdr.New()
+	}
+	hdr.MsgHeaders[key] = []string{value}
+	return nil
+}
+
+// Headers.Get - gets the first value of a key, empty string in case the key does not exist.
+func (hdr Headers) Get(key string) string {
+	if isReservedHeaderKey(key) || len(hdr.MsgHeaders[key]) == 0 {
+		return ""
+	}
+	return hdr.MsgHeaders[key][0]
+}
+
+// Headers.Values - gets all the values of a key.
+func (hdr Headers) Values(key string) []string {
+	if isReservedHeaderKey(key) {
+		return nil
+	}
+	return append([]string(nil), hdr.MsgHeaders[key]...)
+}
+
+// Headers.Del - deletes a key and its values.
+func (hdr *Headers) Del(key string) error {
+	if err := validateHeaderKey(key); err != nil {
+		return err
+	}
+	delete(hdr.MsgHeaders, key)
+	return nil
+}
+
+// Headers.Range - calls f for every key and its values, stops when f returns false.
+func (hdr Headers) Range(f func(key string, values []string) bool) {
+	for key, values := range hdr.MsgHeaders {
+		if isReservedHeaderKey(key) {
+			continue
+		}
+		if !f(key, append([]string(nil), values...)) {
+			return
+		}
+	}
+}
+
+// Headers.Len - the number of keys.
+func (hdr Headers) Len() int {
+	n := 0
+	for key := range hdr.MsgHeaders {
+		if !isReservedHeaderKey(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// Headers.Clone - a deep copy of the headers.
+func (hdr Headers) Clone() Headers {
+	return Headers{MsgHeaders: copyHeaders(hdr.MsgHeaders)}
+}
+
+// Headers.SetInt - sets an integer value of a key.
+func (hdr *Headers) SetInt(key string, value int64) error {
+	return hdr.Set(key, strconv.FormatInt(value, 10))
+}
+
+// Headers.GetInt - gets the first value of a key as an integer.
+func (hdr Headers) GetInt(key string) (int64, error) {
+	v, err := strconv.ParseInt(hdr.Get(key), 10, 64)
+	if err != nil {
+		return 0, memphisError(err)
+	}
+	return v, nil
+}
+
+// Headers.SetBool - sets a boolean value of a key.
+func (hdr *Headers) SetBool(key string, value bool) error {
+	return hdr.Set(key, strconv.FormatBool(value))
+}
+
+// Headers.GetBool - gets the first value of a key as a boolean.
+func (hdr Headers) GetBool(key string) (bool, error) {
+	v, err := strconv.ParseBool(hdr.Get(key))
+	if err != nil {
+		return false, memphisError(err)
+	}
+	return v, nil
+}
+
+// Headers.SetTime - sets a time value of a key, formatted as RFC 3339 with nanoseconds.
+func (hdr *Headers) SetTime(key string, value time.Time) error {
+	return hdr.Set(key, value.Format(time.RFC3339Nano))
+}
+
+// Headers.GetTime - gets the first value of a key as a time.
+func (hdr Headers) GetTime(key string) (time.Time, error) {
+	v, err := time.Parse(time.RFC3339Nano, hdr.Get(key))
+	if err != nil {
+		return time.Time{}, memphisError(err)
+	}
+	return v, nil
+}
+
+// userHeaders - the headers of a received message without the reserved keys.
+func userHeaders(headers map[string][]string) Headers {
+	hdr := Headers{MsgHeaders: make(map[string][]string, len(headers))}
+	for key, values := range headers {
+		if isReservedHeaderKey(key) {
+			continue
+		}
+		hdr.MsgHeaders[key] = append([]string(nil), values...)
+	}
+	return hdr
+}
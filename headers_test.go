@@ -0,0 +1,90 @@
+package memphis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestHeaders(t *testing.T) {
+	var hdrs Headers
+	if err := hdrs.Add("key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdrs.Add("key", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if values := hdrs.Values("key"); len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if err := hdrs.Set("key", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if v := hdrs.Get("key"); v != "c" {
+		t.Errorf("unexpected value %q", v)
+	}
+
+	if err := hdrs.Add("$memphis_producedBy", "a"); err == nil {
+		t.Error("expected reserved keys to be rejected")
+	}
+	hdrs.MsgHeaders["$memphis_connectionId"] = []string{"id"}
+	if v := hdrs.Get("$memphis_connectionId"); v != "" {
+		t.Errorf("reserved keys should not be readable, got %q", v)
+	}
+	if hdrs.Len() != 1 {
+		t.Errorf("unexpected number of keys %v", hdrs.Len())
+	}
+
+	now := time.Now()
+	if err := hdrs.SetTime("time", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdrs.SetInt("int", -7); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdrs.SetBool("bool", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := hdrs.GetTime("time"); err != nil || !v.Equal(now) {
+		t.Errorf("unexpected time %v: %v", v, err)
+	}
+	if v, err := hdrs.GetInt("int"); err != nil || v != -7 {
+		t.Errorf("unexpected int %v: %v", v, err)
+	}
+	if v, err := hdrs.GetBool("bool"); err != nil || !v {
+		t.Errorf("unexpected bool %v: %v", v, err)
+	}
+	if _, err := hdrs.GetInt("key"); err == nil {
+		t.Error("expected an error for a non integer value")
+	}
+
+	clone := hdrs.Clone()
+	if err := clone.Del("key"); err != nil {
+		t.Fatal(err)
+	}
+	if hdrs.Get("key") != "c" {
+		t.Error("deleting from a clone should not change the original headers")
+	}
+}
+
+func TestMsgHeaders(t *testing.T) {
+	msg := &Msg{msg: &nats.Msg{Header: nats.Header{
+		"key":                 {"a", "b"},
+		"$memphis_producedBy": {"producer"},
+	}}}
+	if values := msg.Headers().Values("key"); len(values) != 2 {
+		t.Errorf("expected all values, got %v", values)
+	}
+	headers := msg.GetHeaders()
+	if len(headers) != 1 || headers["key"] != "a" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	var hdrs Headers
+	hdrs.MsgHeaders = map[string][]string{"$memphis_producedBy": {"producer"}}
+	opts := getDefaultProduceOpts()
+	if err := MsgHeaders(hdrs)(&opts); err == nil {
+		t.Error("expected reserved keys to be rejected")
+	}
+}
@@ -0,0 +1,137 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var ConsumerErrInProgressDlsMsg = errors.New("cannot extend the ack deadline of a DLS message")
+
+// inProgressHeartbeat - periodically extends the ack deadline of a message until stopped.
+type inProgressHeartbeat struct {
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (hb *inProgressHeartbeat) stop() {
+	hb.stopOnce.Do(func() { close(hb.stopCh) })
+}
+
+// Msg.InProgress - resets the ack deadline of the message, so it is not redelivered while it is still being processed.
+// Dead-letter messages can not be extended and return ConsumerErrInProgressDlsMsg.
+func (m *Msg) InProgress() error {
+	if jsMsg, ok := m.msg.(jetstream.Msg); ok {
+		return jsMsg.InProgress()
+	}
+	if _, ok := m.msg.(*nats.Msg); ok {
+		return ConsumerErrInProgressDlsMsg
+	}
+	return errors.New("message format is not supported")
+}
+
+// startInProgressHeartbeat - extends the ack deadline of the message every interval until the message is acked, nacked,
+// delayed or dead lettered, or the heartbeat is stopped. Errors are passed to onErr.
+func (m *Msg) startInProgressHeartbeat(interval time.Duration, onErr func(error)) {
+	if _, ok := m.msg.(jetstream.Msg); !ok {
+		return
+	}
+	hb := &inProgressHeartbeat{stopCh: make(chan struct{})}
+	m.heartbeatMu.Lock()
+	m.heartbeat = hb
+	m.heartbeatMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// the stop is checked again under the lock, since a tick and the stop may be ready together
+				m.heartbeatMu.Lock()
+				if m.heartbeat != hb {
+					m.heartbeatMu.Unlock()
+					return
+				}
+				err := m.InProgress()
+				m.heartbeatMu.Unlock()
+				if err != nil {
+					onErr(err)
+				}
+			case <-hb.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stopInProgressHeartbeat - stops extending the ack deadline of the message, if it was being extended.
+// Once it returns the ack deadline is not extended anymore.
+func (m *Msg) stopInProgressHeartbeat() {
+	m.heartbeatMu.Lock()
+	hb := m.heartbeat
+	m.heartbeat = nil
+	m.heartbeatMu.Unlock()
+	if hb != nil {
+		hb.stop()
+	}
+}
+
+// withInProgressHeartbeat - extends the ack deadline of the messages of a batch while the handler is running.
+func (c *Consumer) withInProgressHeartbeat(handlerFunc ConsumeHandler, interval time.Duration) ConsumeHandler {
+	return c.startingInProgressHeartbeat(stoppingInProgressHeartbeat(handlerFunc), interval)
+}
+
+// startingInProgressHeartbeat - starts extending the ack deadline of the messages of a batch before passing them on,
+// so messages waiting for a worker of the pool are extended as well.
+func (c *Consumer) startingInProgressHeartbeat(handlerFunc ConsumeHandler, interval time.Duration) ConsumeHandler {
+	return func(msgs []*Msg, err error, ctx context.Context) {
+		for _, msg := range msgs {
+			msg.startInProgressHeartbeat(interval, c.callErrHandler)
+		}
+		handlerFunc(msgs, err, ctx)
+	}
+}
+
+// stoppingInProgressHeartbeat - stops extending the ack deadline of the messages of a handler call once it returns.
+func stoppingInProgressHeartbeat(handlerFunc ConsumeHandler) ConsumeHandler {
+	return func(msgs []*Msg, err error, ctx context.Context) {
+		defer func() {
+			for _, msg := range msgs {
+				msg.stopInProgressHeartbeat()
+			}
+		}()
+		handlerFunc(msgs, err, ctx)
+	}
+}
+
+// AutoInProgress - extends the ack deadline of messages every interval while the Consume handler is running,
+// until the message is acked, nacked, delayed or dead lettered. The interval should be well below the max ack time.
+// With Concurrency, messages are extended from when they are fetched until their own handler call returns.
+func AutoInProgress(interval time.Duration) ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		if interval <= 0 {
+			return errors.New("in progress interval has to be a positive duration")
+		}
+		opts.InProgressInterval = interval
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// testInProgressMsg - signals every deadline extension, which has to happen before the message is acked.
+type testInProgressMsg struct {
+	testAckMsg
+	mu         sync.Mutex
+	inProgress int
+	afterAck   bool
+	extended   chan struct{}
+}
+
+func newTestInProgressMsg() *testInProgressMsg {
+	return &testInProgressMsg{extended: make(chan struct{}, 100)}
+}
+
+func (m *testInProgressMsg) Ack() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked = true
+	return nil
+}
+
+func (m *testInProgressMsg) InProgress() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.acked {
+		m.afterAck = true
+	}
+	m.inProgress++
+	select {
+	case m.extended <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (m *testInProgressMsg) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inProgress
+}
+
+func waitExtended(t *testing.T, m *testInProgressMsg, times int) {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		select {
+		case <-m.extended:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the ack deadline to be extended")
+		}
+	}
+}
+
+func TestAutoInProgress(t *testing.T) {
+	if err := AutoInProgress(0)(&ConsumingOpts{}); err == nil {
+		t.Error("expected error for a zero interval")
+	}
+
+	var errs []error
+	c := &Consumer{errHandler: func(_ *Consumer, err error) { errs = append(errs, err) }}
+	acked, unacked := newTestInProgressMsg(), newTestInProgressMsg()
+	ackedMsg, unackedMsg := &Msg{msg: acked}, &Msg{msg: unacked}
+	handler := c.withInProgressHeartbeat(func(msgs []*Msg, err error, ctx context.Context) {
+		waitExtended(t, acked, 2)
+		if err := ackedMsg.Ack(); err != nil {
+			t.Error(err)
+		}
+		ackedCount := acked.count()
+		// the other message keeps being extended while the acked one is not
+		for len(unacked.extended) > 0 {
+			<-unacked.extended
+		}
+		waitExtended(t, unacked, 3)
+		if acked.count() != ackedCount {
+			t.Errorf("expected the heartbeat to stop on ack, got %v extensions after it", acked.count()-ackedCount)
+		}
+	}, time.Millisecond)
+	handler([]*Msg{ackedMsg, unackedMsg}, nil, nil)
+
+	unackedCount := unacked.count()
+	unackedMsg.heartbeatMu.Lock()
+	stopped := unackedMsg.heartbeat == nil
+	unackedMsg.heartbeatMu.Unlock()
+	if !stopped || unacked.count() != unackedCount {
+		t.Error("expected the heartbeat to stop once the handler returned")
+	}
+	if acked.afterAck || len(errs) > 0 {
+		t.Errorf("expected no extension after the ack, got errors %v", errs)
+	}
+
+	if err := (&Msg{msg: &nats.Msg{}}).InProgress(); !errors.Is(err, ConsumerErrInProgressDlsMsg) {
+		t.Errorf("expected the dls message error, got %v", err)
+	}
+}
+
+func TestAutoInProgressConcurrency(t *testing.T) {
+	tc := &testStreamingJsConsumer{}
+	c := &Consumer{
+		stationName:   "test_station",
+		jsConsumers:   map[int]jetstream.Consumer{1: tc},
+		expiredPolicy: ExpiredDeliver,
+		consumeMode:   ConsumeStreaming,
+	}
+	c.subscriptionActive.Store(true)
+
+	release := make(chan struct{})
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		<-release
+		for _, msg := range msgs {
+			if err := msg.Ack(); err != nil {
+				t.Error(err)
+			}
+		}
+	}, Concurrency(2), OrderingKey(OrderByHeader("key")), AutoInProgress(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, queued, blocked := newTestInProgressMsg(), newTestInProgressMsg(), newTestInProgressMsg()
+	running.headers = nats.Header{"key": []string{"a"}}
+	queued.headers = nats.Header{"key": []string{"a"}}
+	blocked.headers = nats.Header{"key": []string{"b"}}
+	tc.handler(running)
+	// waits behind the message with the same ordering key
+	tc.handler(queued)
+	dispatched := make(chan struct{})
+	go func() {
+		// waits for a free slot of the pool
+		tc.handler(blocked)
+		close(dispatched)
+	}()
+	waitExtended(t, queued, 2)
+	waitExtended(t, blocked, 2)
+
+	close(release)
+	<-dispatched
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*testInProgressMsg{running, queued, blocked} {
+		m.mu.Lock()
+		if !m.acked || m.afterAck {
+			t.Error("expected every message to be acked with no extension after the ack")
+		}
+		m.mu.Unlock()
+	}
+}
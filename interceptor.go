@@ -0,0 +1,59 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// InterceptedMsg - a message on its way to the broker, as seen by producer interceptors.
+type InterceptedMsg struct {
+	Message any
+	Headers *Headers
+}
+
+// ProducerInterceptor - hooks into every message produced by a producer.
+type ProducerInterceptor interface {
+	// BeforeProduce - called before the message is validated against the station schema. The message and its headers
+	// can be changed, returning an error vetoes the send and the error is returned from produce.
+	BeforeProduce(p *Producer, msg *InterceptedMsg) error
+	// AfterAck - called once the broker has acked the message, or with the error in case sending it has failed.
+	// Messages stored in the local outbox are not acked, so it is not called for them.
+	AfterAck(p *Producer, msg *InterceptedMsg, ack *jetstream.PubAck, err error)
+}
+
+func (p *Producer) beforeProduce(msg *InterceptedMsg) error {
+	for _, interceptor := range p.interceptors {
+		if err := interceptor.BeforeProduce(p, msg); err != nil {
+			return err
+		}
+		if msg.Headers.MsgHeaders == nil {
+			msg.Headers.New()
+		}
+	}
+	return nil
+}
+
+func (p *Producer) afterAck(msg *InterceptedMsg, ack *jetstream.PubAck, err error) {
+	for _, interceptor := range p.interceptors {
+		interceptor.AfterAck(p, msg, ack, err)
+	}
+}
+
+func (p *Producer) initInterceptors(opts ProducerOpts) {
+	interceptors := make([]ProducerInterceptor, 0, len(p.conn.opts.ProducerInterceptors)+len(opts.Interceptors))
+	interceptors = append(interceptors, p.conn.opts.ProducerInterceptors...)
+	p.interceptors = append(interceptors, opts.Interceptors...)
+}
@@ -0,0 +1,71 @@
+package memphis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testInterceptor struct {
+	name  string
+	calls *[]string
+	veto  error
+}
+
+func (ti testInterceptor) BeforeProduce(p *Producer, msg *InterceptedMsg) error {
+	*ti.calls = append(*ti.calls, "before_"+ti.name)
+	if ti.veto != nil {
+		return ti.veto
+	}
+	msg.Message = append(msg.Message.([]byte), ti.name...)
+	return msg.Headers.Add("tenant", ti.name)
+}
+
+func (ti testInterceptor) AfterAck(p *Producer, msg *InterceptedMsg, ack *jetstream.PubAck, err error) {
+	*ti.calls = append(*ti.calls, "after_"+ti.name)
+}
+
+func TestProducerInterceptors(t *testing.T) {
+	var calls []string
+	errVeto := errors.New("payload too large")
+	c := &Conn{opts: Options{ProducerInterceptors: []ProducerInterceptor{testInterceptor{name: "conn", calls: &calls}}}}
+	opts := getDefaultProducerOpts()
+	if err := ProducerInterceptors(testInterceptor{name: "producer", calls: &calls})(&opts); err != nil {
+		t.Fatal(err)
+	}
+	p := &Producer{conn: c}
+	p.initInterceptors(opts)
+
+	produceOpts := getDefaultProduceOpts()
+	im := &InterceptedMsg{Message: []byte("msg_"), Headers: &produceOpts.MsgHeaders}
+	if err := p.beforeProduce(im); err != nil {
+		t.Fatal(err)
+	}
+	p.afterAck(im, &jetstream.PubAck{}, nil)
+
+	if string(im.Message.([]byte)) != "msg_connproducer" {
+		t.Errorf("unexpected message %q", im.Message)
+	}
+	if tenant := produceOpts.MsgHeaders.Values("tenant"); len(tenant) != 2 || tenant[0] != "conn" || tenant[1] != "producer" {
+		t.Errorf("unexpected tenant header %v", tenant)
+	}
+	expected := []string{"before_conn", "before_producer", "after_conn", "after_producer"}
+	if len(calls) != len(expected) {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("unexpected calls %v", calls)
+		}
+	}
+
+	calls = nil
+	p.interceptors = []ProducerInterceptor{testInterceptor{name: "veto", calls: &calls, veto: errVeto}, testInterceptor{name: "producer", calls: &calls}}
+	if err := p.beforeProduce(im); !errors.Is(err, errVeto) {
+		t.Errorf("expected the send to be vetoed, got %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("interceptors after a veto should not run, calls: %v", calls)
+	}
+}
@@ -0,0 +1,173 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MsgIterator - pulls messages from a consumer one at a time, fetching a batch whenever its buffer runs out.
+type MsgIterator struct {
+	consumer *Consumer
+	opts     ConsumingOpts
+	mu       sync.Mutex
+	buffer   []*Msg
+}
+
+func getConsumingOpts(opts []ConsumingOpt) (ConsumingOpts, error) {
+	defaultOpts := getDefaultConsumingOptions()
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt(&defaultOpts); err != nil {
+				return defaultOpts, err
+			}
+		}
+	}
+	return defaultOpts, nil
+}
+
+// Consumer.Iterator - creates an iterator over the messages of the consumer, messages are fetched in batches of the consumer batch size.
+func (c *Consumer) Iterator(opts ...ConsumingOpt) (*MsgIterator, error) {
+	consumingOpts, err := getConsumingOpts(opts)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return &MsgIterator{consumer: c, opts: consumingOpts}, nil
+}
+
+// MsgIterator.Next - returns the next message, blocking until one arrives or the context is done.
+// A fetch does not outlive the context deadline, a canceled context is noticed once the pending fetch returns.
+func (it *MsgIterator) Next(ctx context.Context) (*Msg, error) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	for len(it.buffer) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msgs, err := it.consumer.fetchMsgs(ctx, it.opts.ConsumerPartitionKey, it.opts.ConsumerPartitionNumber)
+		if err == context.DeadlineExceeded || err == context.Canceled {
+			return nil, err
+		}
+		if err != nil {
+			return nil, memphisError(err)
+		}
+		it.buffer = msgs
+	}
+	msg := it.buffer[0]
+	it.buffer[0] = nil
+	it.buffer = it.buffer[1:]
+	return msg, nil
+}
+
+// Consumer.Messages - starts consuming messages into a channel until the context is done, then both channels are closed.
+// A fetch is made every pull interval, or right away after a full batch. Errors are sent on the error channel,
+// errors which are not received in time are passed to the consumer error handler instead.
+// Messages which were fetched but not received are redelivered after the max ack time.
+func (c *Consumer) Messages(ctx context.Context, opts ...ConsumingOpt) (<-chan *Msg, <-chan error) {
+	msgsCh := make(chan *Msg, c.BatchSize)
+	errCh := make(chan error, 1)
+	consumingOpts, err := getConsumingOpts(opts)
+	if err != nil {
+		errCh <- memphisError(err)
+		close(msgsCh)
+		close(errCh)
+		return msgsCh, errCh
+	}
+
+	go func() {
+		defer close(msgsCh)
+		defer close(errCh)
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+
+			msgs, err := c.fetchMsgs(ctx, consumingOpts.ConsumerPartitionKey, consumingOpts.ConsumerPartitionNumber)
+			if err != nil && ctx.Err() == nil {
+				select {
+				case errCh <- memphisError(err):
+				default:
+					c.callErrHandler(memphisError(err))
+				}
+			}
+			for _, msg := range msgs {
+				select {
+				case msgsCh <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if len(msgs) == c.BatchSize {
+				timer.Reset(0)
+			} else {
+				timer.Reset(c.PullInterval)
+			}
+		}
+	}()
+	return msgsCh, errCh
+}
+
+// fetchMsgs - fetches a batch of messages, dead letter messages first, waiting no longer than the context deadline.
+// Unlike the fetch of the consume loop, errors are returned to the caller and do not deactivate the consumer.
+func (c *Consumer) fetchMsgs(ctx context.Context, partitionKey string, partitionNum int) ([]*Msg, error) {
+	if msgs := c.takeDlsMsgs(c.BatchSize); len(msgs) > 0 {
+		return msgs, nil
+	}
+	if !c.subscriptionActive.Load() {
+		return nil, ConsumerErrStationUnreachable
+	}
+	partitionNumber, err := c.fetchPartition(partitionKey, partitionNum)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetchFromPartition(ctx, partitionNumber, c.BatchSize)
+}
+
+// fetchFromPartition - fetches up to batchSize messages from a partition, waiting no longer than the context deadline.
+func (c *Consumer) fetchFromPartition(ctx context.Context, partitionNumber int, batchSize int) ([]*Msg, error) {
+	maxWait := c.BatchMaxTimeToWait
+	if deadline, ok := ctx.Deadline(); ok {
+		if untilDeadline := time.Until(deadline); untilDeadline < maxWait {
+			maxWait = untilDeadline
+		}
+	}
+	if maxWait <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+
+	batch, err := c.partitionConsumers()[partitionNumber].Fetch(batchSize, jetstream.FetchMaxWait(maxWait))
+	if err != nil && err != nats.ErrTimeout {
+		return nil, err
+	}
+	internalStationName := getInternalName(c.stationName)
+	wrappedMsgs := make([]*Msg, 0, batchSize)
+	for msg := range batch.Messages() {
+		wrappedMsgs = append(wrappedMsgs, &Msg{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, internalStationName: internalStationName, partition: partitionNumber})
+	}
+	if err := batch.Error(); err != nil && err != nats.ErrTimeout && len(wrappedMsgs) == 0 {
+		return nil, err
+	}
+	return c.deferNotDueMsgs(c.handleExpiredMsgs(wrappedMsgs)), nil
+}
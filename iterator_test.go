@@ -0,0 +1,173 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testJsMsg struct {
+	jetstream.Msg
+	data    []byte
+	headers nats.Header
+}
+
+func (m *testJsMsg) Data() []byte         { return m.data }
+func (m *testJsMsg) Headers() nats.Header { return m.headers }
+
+type testMsgBatch struct {
+	msgs chan jetstream.Msg
+	err  error
+}
+
+func (b *testMsgBatch) Messages() <-chan jetstream.Msg { return b.msgs }
+func (b *testMsgBatch) Error() error                   { return b.err }
+
+// testJsConsumer - returns the queued messages in batches, an empty batch after the max wait once none are left.
+type testJsConsumer struct {
+	jetstream.Consumer
+	mu      sync.Mutex
+	pending []jetstream.Msg
+	err     error
+	fetches int
+}
+
+func (tc *testJsConsumer) queue(data ...string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for _, d := range data {
+		tc.pending = append(tc.pending, &testJsMsg{data: []byte(d)})
+	}
+}
+
+func (tc *testJsConsumer) Fetch(batch int, opts ...jetstream.FetchOpt) (jetstream.MessageBatch, error) {
+	tc.mu.Lock()
+	tc.fetches++
+	if tc.err != nil {
+		tc.mu.Unlock()
+		return nil, tc.err
+	}
+	n := batch
+	if len(tc.pending) < n {
+		n = len(tc.pending)
+	}
+	msgs := make(chan jetstream.Msg, n)
+	for _, msg := range tc.pending[:n] {
+		msgs <- msg
+	}
+	tc.pending = tc.pending[n:]
+	tc.mu.Unlock()
+	close(msgs)
+	if n == 0 {
+		time.Sleep(5 * time.Millisecond)
+		return &testMsgBatch{msgs: msgs, err: nats.ErrTimeout}, nil
+	}
+	return &testMsgBatch{msgs: msgs}, nil
+}
+
+func newTestConsumer(tc *testJsConsumer) *Consumer {
+	c := &Consumer{
+		stationName:        "test_station",
+		ConsumerGroup:      "test_cg",
+		BatchSize:          2,
+		BatchMaxTimeToWait: time.Second,
+		PullInterval:       10 * time.Millisecond,
+		jsConsumers:        map[int]jetstream.Consumer{1: tc},
+		expiredPolicy:      ExpiredDeliver,
+		errHandler:         func(*Consumer, error) {},
+	}
+	c.subscriptionActive.Store(true)
+	return c
+}
+
+func TestMsgIterator(t *testing.T) {
+	tc := &testJsConsumer{}
+	tc.queue("a", "b", "c")
+	c := newTestConsumer(tc)
+	c.dlsMsgs = []*Msg{{msg: &testJsMsg{data: []byte("dls")}}}
+
+	it, err := c.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, expected := range []string{"dls", "a", "b", "c"} {
+		msg, err := it.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data()) != expected {
+			t.Errorf("expected %v, got %v", expected, string(msg.Data()))
+		}
+	}
+	if tc.fetches != 2 {
+		t.Errorf("expected the iterator to buffer batches, got %v fetches", tc.fetches)
+	}
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer shortCancel()
+	if _, err := it.Next(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	tc.err = errors.New("fetch failed")
+	if _, err := it.Next(ctx); err == nil || err.Error() != "fetch failed" {
+		t.Errorf("expected the fetch error, got %v", err)
+	}
+}
+
+func TestConsumerMessages(t *testing.T) {
+	tc := &testJsConsumer{}
+	tc.queue("a", "b", "c")
+	c := newTestConsumer(tc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, errs := c.Messages(ctx)
+	for _, expected := range []string{"a", "b", "c"} {
+		select {
+		case msg := <-msgs:
+			if string(msg.Data()) != expected {
+				t.Errorf("expected %v, got %v", expected, string(msg.Data()))
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+
+	tc.mu.Lock()
+	tc.err = errors.New("fetch failed")
+	tc.mu.Unlock()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+
+	cancel()
+	timeout := time.After(time.Second)
+	for msgs != nil || errs != nil {
+		select {
+		case _, ok := <-msgs:
+			if !ok {
+				msgs = nil
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		case <-timeout:
+			t.Fatal("channels were not closed after the context was canceled")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PartitionLag - how far behind the consumer group is on a partition.
+type PartitionLag struct {
+	Pending       uint64
+	AckPending    int
+	Redelivered   int
+	LastDelivered uint64
+}
+
+// ConsumerLag - how far behind the consumer group is, in total and per partition.
+type ConsumerLag struct {
+	Pending     uint64
+	AckPending  int
+	Redelivered int
+	Partitions  map[int]PartitionLag
+}
+
+// LagHandler - called with the lag of the consumer group every lag report interval.
+type LagHandler func(c *Consumer, lag *ConsumerLag)
+
+// Consumer.Lag - returns how far behind the consumer group is: messages not delivered yet, messages delivered and not acked yet,
+// messages being redelivered and the last delivered sequence of each partition.
+func (c *Consumer) Lag(ctx context.Context) (*ConsumerLag, error) {
+	jsConsumers := c.partitionConsumers()
+	lag := &ConsumerLag{Partitions: make(map[int]PartitionLag, len(jsConsumers))}
+	var mu sync.Mutex
+	var lagErr error
+	wg := sync.WaitGroup{}
+	for partitionNumber, jsCons := range jsConsumers {
+		wg.Add(1)
+		go func(partitionNumber int, jsCons jetstream.Consumer) {
+			defer wg.Done()
+			info, err := jsCons.Info(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lagErr = err
+				return
+			}
+			partitionLag := PartitionLag{
+				Pending:       info.NumPending,
+				AckPending:    info.NumAckPending,
+				Redelivered:   info.NumRedelivered,
+				LastDelivered: info.Delivered.Stream,
+			}
+			lag.Partitions[partitionNumber] = partitionLag
+			lag.Pending += partitionLag.Pending
+			lag.AckPending += partitionLag.AckPending
+			lag.Redelivered += partitionLag.Redelivered
+		}(partitionNumber, jsCons)
+	}
+	wg.Wait()
+	if lagErr != nil {
+		return nil, memphisError(lagErr)
+	}
+	return lag, nil
+}
+
+// reportLag - passes the lag to the lag handler every interval until the consumer is destroyed, errors go to the error handler.
+func (c *Consumer) reportLag(interval time.Duration, handler LagHandler, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancelfunc := context.WithTimeout(context.Background(), JetstreamOperationTimeout*time.Second)
+			lag, err := c.Lag(ctx)
+			cancelfunc()
+			if err != nil {
+				c.callErrHandler(err)
+				continue
+			}
+			handler(c, lag)
+		case <-quit:
+			return
+		}
+	}
+}
+
+// LagReport - calls the handler with the lag of the consumer group every interval, for example to scale consumers.
+func LagReport(interval time.Duration, handler LagHandler) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if interval <= 0 {
+			return errors.New("lag report interval has to be a positive duration")
+		}
+		if handler == nil {
+			return errors.New("lag handler can not be nil")
+		}
+		opts.LagReportInterval = interval
+		opts.LagHandler = handler
+		return nil
+	}
+}
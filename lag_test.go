@@ -0,0 +1,69 @@
+package memphis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newLagTestConsumer() *Consumer {
+	jsConsumers := map[int]jetstream.Consumer{}
+	for _, p := range []int{1, 2} {
+		jsConsumers[p] = &testInfoJsConsumer{info: &jetstream.ConsumerInfo{
+			NumPending:     uint64(10 * p),
+			NumAckPending:  p,
+			NumRedelivered: p - 1,
+			Delivered:      jetstream.SequenceInfo{Stream: uint64(100 * p)},
+		}}
+	}
+	return &Consumer{jsConsumers: jsConsumers}
+}
+
+func TestConsumerLag(t *testing.T) {
+	lag, err := newLagTestConsumer().Lag(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.Pending != 30 || lag.AckPending != 3 || lag.Redelivered != 1 {
+		t.Errorf("unexpected totals %+v", *lag)
+	}
+	expected := PartitionLag{Pending: 20, AckPending: 2, Redelivered: 1, LastDelivered: 200}
+	if lag.Partitions[2] != expected {
+		t.Errorf("expected %+v, got %+v", expected, lag.Partitions[2])
+	}
+}
+
+func TestLagReport(t *testing.T) {
+	if err := LagReport(0, func(*Consumer, *ConsumerLag) {})(&ConsumerOpts{}); err == nil {
+		t.Error("expected error for a zero interval")
+	}
+	if err := LagReport(time.Second, nil)(&ConsumerOpts{}); err == nil {
+		t.Error("expected error for a nil handler")
+	}
+
+	c := newLagTestConsumer()
+	quit := make(chan struct{})
+	reports := make(chan *ConsumerLag, 1)
+	done := make(chan struct{})
+	go func() {
+		c.reportLag(5*time.Millisecond, func(_ *Consumer, lag *ConsumerLag) {
+			select {
+			case reports <- lag:
+			default:
+			}
+		}, quit)
+		close(done)
+	}()
+	select {
+	case lag := <-reports:
+		if lag.Pending != 30 {
+			t.Errorf("unexpected lag %+v", *lag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a lag report")
+	}
+	close(quit)
+	<-done
+}
@@ -0,0 +1,149 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ConsumerErrConsumeActive = errors.New("consumer is already consuming")
+	ConsumerErrDestroyed     = errors.New("consumer is destroyed")
+)
+
+// ConsumerState - the lifecycle state of a consumer.
+type ConsumerState int
+
+const (
+	// ConsumerIdle - the consumer is not consuming, Consume can be called.
+	ConsumerIdle ConsumerState = iota
+	// ConsumerRunning - Consume was called and was not stopped yet.
+	ConsumerRunning
+	// ConsumerStopping - the consume was stopped and the current handler call has not returned yet.
+	ConsumerStopping
+	// ConsumerDestroyed - the consumer was destroyed and can not be used anymore.
+	ConsumerDestroyed
+)
+
+func (s ConsumerState) String() string {
+	return [...]string{"idle", "running", "stopping", "destroyed"}[s]
+}
+
+type consumeRunKey struct{}
+
+// consumeRun - a single run of Consume, from the call until the consume is stopped and its handler has returned.
+type consumeRun struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	handlerCtx context.Context
+	pool       *workerPool
+	streams    *consumeStreams
+	dlsHandler ConsumeHandler
+}
+
+// Consumer.State - returns the lifecycle state of the consumer.
+func (c *Consumer) State() ConsumerState {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	return c.state
+}
+
+// startConsume - moves an idle consumer to running. The context passed to the handler carries the run, so a stop from
+// within the handler does not wait for the handler to return.
+func (c *Consumer) startConsume() (*consumeRun, error) {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	switch c.state {
+	case ConsumerRunning, ConsumerStopping:
+		return nil, ConsumerErrConsumeActive
+	case ConsumerDestroyed:
+		return nil, ConsumerErrDestroyed
+	}
+	if c.destroying {
+		return nil, ConsumerErrDestroyed
+	}
+
+	handlerCtx := c.context
+	if handlerCtx == nil {
+		handlerCtx = context.Background()
+	}
+	run := &consumeRun{done: make(chan struct{})}
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+	run.handlerCtx = context.WithValue(handlerCtx, consumeRunKey{}, run)
+	c.state = ConsumerRunning
+	c.run = run
+	return run, nil
+}
+
+// setDlsHandler - passes the dead-letter messages which arrive while the run is running to its handler.
+func (c *Consumer) setDlsHandler(run *consumeRun, handlerFunc ConsumeHandler) {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	run.dlsHandler = handlerFunc
+}
+
+// requestStop - moves a running consumer to stopping without waiting, returns nil when the consumer is not running.
+func (c *Consumer) requestStop() *consumeRun {
+	c.lifecycleMu.Lock()
+	defer c.lifecycleMu.Unlock()
+	if c.state != ConsumerRunning {
+		return nil
+	}
+	c.state = ConsumerStopping
+	c.run.cancel()
+	return c.run
+}
+
+// finishConsume - called once a stopped run is done handling messages, moves the consumer back to idle.
+func (c *Consumer) finishConsume(run *consumeRun) {
+	if run.pool != nil {
+		run.pool.wait()
+	}
+	c.lifecycleMu.Lock()
+	if c.run == run {
+		if c.state == ConsumerStopping {
+			c.state = ConsumerIdle
+		}
+		c.run = nil
+	}
+	c.lifecycleMu.Unlock()
+	close(run.done)
+}
+
+// Consumer.Stop - stops consuming and waits for the current handler call to return, or for the context to be done.
+// Calling it when the consumer is not consuming does nothing. When called from within the handler with the context
+// the handler got, it does not wait for the handler to return.
+func (c *Consumer) Stop(ctx context.Context) error {
+	run := c.requestStop()
+	if run == nil {
+		c.lifecycleMu.Lock()
+		run = c.run
+		c.lifecycleMu.Unlock()
+		if run == nil {
+			return nil
+		}
+	}
+	if ctx.Value(consumeRunKey{}) == run {
+		return nil
+	}
+	select {
+	case <-run.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
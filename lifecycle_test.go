@@ -0,0 +1,153 @@
+package memphis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestConsumerLifecycle(t *testing.T) {
+	tc := &testJsConsumer{}
+	tc.queue("a", "b")
+	c := newTestConsumer(tc)
+	var errs []error
+	c.errHandler = func(_ *Consumer, err error) { errs = append(errs, err) }
+
+	if err := c.Stop(context.Background()); err != nil || c.State() != ConsumerIdle {
+		t.Fatalf("expected stopping an idle consumer to do nothing, got %v", err)
+	}
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		if len(msgs) == 0 {
+			return
+		}
+		close(handling)
+		<-release
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != ConsumerRunning {
+		t.Errorf("expected the consumer to be running, got %v", c.State())
+	}
+	if err := c.Consume(func([]*Msg, error, context.Context) {}); err != ConsumerErrConsumeActive {
+		t.Errorf("expected a second consume to fail, got %v", err)
+	}
+
+	<-handling
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected stop to wait for the handler, got %v", err)
+	}
+	if c.State() != ConsumerStopping {
+		t.Errorf("expected the consumer to be stopping, got %v", c.State())
+	}
+	close(release)
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Errorf("expected a repeated stop to do nothing, got %v", err)
+	}
+	if c.State() != ConsumerIdle {
+		t.Errorf("expected the consumer to be idle, got %v", c.State())
+	}
+
+	c.StopConsume()
+	if len(errs) != 1 || errs[0] != ConsumerErrConsumeInactive {
+		t.Errorf("expected stopping an idle consumer to report it, got %v", errs)
+	}
+
+	// a consumer which failed to be destroyed is not destroyed, but can not consume anymore
+	c.conn = &Conn{stationUpdatesSubs: map[string]*stationUpdateSub{}}
+	if err := c.Destroy(); err == nil {
+		t.Fatal("expected destroy to fail without a schema listener")
+	}
+	if c.State() == ConsumerDestroyed || !c.listenerRemoved {
+		t.Errorf("expected the consumer not to be destroyed yet, got %v", c.State())
+	}
+	if err := c.Consume(func([]*Msg, error, context.Context) {}); err != ConsumerErrDestroyed {
+		t.Errorf("expected consume to fail once destroy was called, got %v", err)
+	}
+
+	// a destroyed consumer is not torn down again
+	c.state = ConsumerDestroyed
+	if err := c.Destroy(); err != nil {
+		t.Errorf("expected a repeated destroy to do nothing, got %v", err)
+	}
+}
+
+func TestConsumerDlsHandlerOfRun(t *testing.T) {
+	tc := &testStreamingJsConsumer{}
+	c := &Consumer{
+		stationName:   "test_station",
+		ConsumerGroup: "test_cg",
+		jsConsumers:   map[int]jetstream.Consumer{1: tc},
+		expiredPolicy: ExpiredDeliver,
+		consumeMode:   ConsumeStreaming,
+	}
+	c.subscriptionActive.Store(true)
+	dlsHandler := c.createDlsMsgHandler()
+
+	var first, second int
+	if err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) { first += len(msgs) }); err != nil {
+		t.Fatal(err)
+	}
+	dlsHandler(&nats.Msg{Data: []byte("a")})
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// kept for fetches while no consume is running
+	dlsHandler(&nats.Msg{Data: []byte("b")})
+	if err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) { second += len(msgs) }); err != nil {
+		t.Fatal(err)
+	}
+	dlsHandler(&nats.Msg{Data: []byte("c")})
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || second != 1 || len(c.dlsMsgs) != 1 {
+		t.Errorf("expected every run to get its own dead-letter messages, got %v and %v with %v kept", first, second, len(c.dlsMsgs))
+	}
+}
+
+func TestConsumerStopFromHandler(t *testing.T) {
+	tc := &testJsConsumer{}
+	tc.queue("a")
+	c := newTestConsumer(tc)
+
+	stopped := make(chan error, 1)
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		if len(msgs) > 0 {
+			stopped <- c.Stop(ctx)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stop from within the handler has deadlocked")
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer can consume again once stopped
+	if err := c.Consume(func([]*Msg, error, context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
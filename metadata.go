@@ -0,0 +1,82 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MsgMetadata - where a message comes from and how it was delivered.
+// Messages resent from the dead-letter station are not delivered from the station stream, so their stream fields are zero.
+type MsgMetadata struct {
+	StationName      string
+	Partition        int
+	ConsumerGroup    string
+	StreamSequence   uint64
+	ConsumerSequence uint64
+	NumDelivered     uint64
+	NumPending       uint64
+	Timestamp        time.Time
+	ProducedBy       string
+	ConnectionId     string
+	FromDls          bool
+}
+
+// Msg.Metadata - returns the metadata of the message.
+func (m *Msg) Metadata() (*MsgMetadata, error) {
+	headers := m.natsHeaders()
+	_, fromDls := headers["$memphis_pm_id"]
+	md := &MsgMetadata{
+		StationName:   m.internalStationName,
+		Partition:     m.partition,
+		ConsumerGroup: m.cgName,
+		ProducedBy:    headers.Get("$memphis_producedBy"),
+		ConnectionId:  headers.Get("$memphis_connectionId"),
+		FromDls:       fromDls,
+	}
+
+	switch msg := m.msg.(type) {
+	case jetstream.Msg:
+		meta, err := msg.Metadata()
+		if err != nil {
+			return nil, memphisError(err)
+		}
+		md.StreamSequence = meta.Sequence.Stream
+		md.ConsumerSequence = meta.Sequence.Consumer
+		md.NumDelivered = meta.NumDelivered
+		md.NumPending = meta.NumPending
+		md.Timestamp = meta.Timestamp
+	case *nats.Msg:
+		meta, err := msg.Metadata()
+		if err != nil {
+			if fromDls {
+				return md, nil
+			}
+			return nil, memphisError(err)
+		}
+		md.StreamSequence = meta.Sequence.Stream
+		md.ConsumerSequence = meta.Sequence.Consumer
+		md.NumDelivered = meta.NumDelivered
+		md.NumPending = meta.NumPending
+		md.Timestamp = meta.Timestamp
+	default:
+		return nil, errors.New("message format is not supported")
+	}
+	return md, nil
+}
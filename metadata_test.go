@@ -0,0 +1,66 @@
+package memphis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testMetadataMsg struct {
+	testJsMsg
+	meta *jetstream.MsgMetadata
+}
+
+func (m *testMetadataMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return m.meta, nil
+}
+
+func TestMsgMetadata(t *testing.T) {
+	sent := time.Now()
+	jsMsg := &testMetadataMsg{
+		testJsMsg: testJsMsg{headers: nats.Header{"$memphis_producedBy": []string{"producer"}, "$memphis_connectionId": []string{"conn"}}},
+		meta: &jetstream.MsgMetadata{
+			Sequence:     jetstream.SequencePair{Stream: 7, Consumer: 3},
+			NumDelivered: 2,
+			NumPending:   5,
+			Timestamp:    sent,
+		},
+	}
+	md, err := (&Msg{msg: jsMsg, cgName: "cg", internalStationName: "station", partition: 2}).Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MsgMetadata{
+		StationName:      "station",
+		Partition:        2,
+		ConsumerGroup:    "cg",
+		StreamSequence:   7,
+		ConsumerSequence: 3,
+		NumDelivered:     2,
+		NumPending:       5,
+		Timestamp:        sent,
+		ProducedBy:       "producer",
+		ConnectionId:     "conn",
+	}
+	if *md != expected {
+		t.Errorf("expected %+v, got %+v", expected, *md)
+	}
+
+	dlsMsg := &nats.Msg{Header: nats.Header{"$memphis_pm_id": []string{"1"}, "$memphis_pm_cg_name": []string{"cg"}}}
+	md, err = (&Msg{msg: dlsMsg}).Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.FromDls || md.StreamSequence != 0 {
+		t.Errorf("unexpected dls message metadata %+v", *md)
+	}
+
+	if _, err := (&Msg{msg: &nats.Msg{}}).Metadata(); err == nil {
+		t.Error("expected an error for a message without metadata")
+	}
+	if _, err := (&Msg{msg: "unsupported"}).Metadata(); err == nil {
+		t.Error("expected an error for an unsupported message")
+	}
+}
@@ -0,0 +1,149 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+const msgIdHeader = "msg-id"
+
+// MsgIdStrategy - generates the id of a message from its payload, after schema validation, and its headers.
+// The broker drops messages whose id it has already seen within the station's idempotency window.
+type MsgIdStrategy func(data []byte, headers map[string][]string) (string, error)
+
+// MsgIdUUIDv7 - time ordered random message ids.
+func MsgIdUUIDv7() MsgIdStrategy {
+	return func(data []byte, headers map[string][]string) (string, error) {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+}
+
+// MsgIdContentHash - message ids hashed from the payload and the values of the given headers,
+// so producing the same content twice is detected as a duplicate.
+func MsgIdContentHash(headerKeys ...string) MsgIdStrategy {
+	return func(data []byte, headers map[string][]string) (string, error) {
+		h := sha256.New()
+		h.Write(data)
+		for _, key := range headerKeys {
+			h.Write([]byte{0})
+			h.Write([]byte(key))
+			for _, value := range headers[key] {
+				h.Write([]byte{0})
+				h.Write([]byte(value))
+			}
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+// ProduceResult - the broker ack of a produced message.
+type ProduceResult struct {
+	MsgId     string
+	Stream    string
+	Sequence  uint64
+	Duplicate bool
+}
+
+// setMsgId - sets the message id using the producer strategy, unless the message already has one.
+func (p *Producer) setMsgId(data []byte, headers map[string][]string) error {
+	if p.msgIdStrategy == nil || len(headers[msgIdHeader]) > 0 {
+		return nil
+	}
+	id, err := p.msgIdStrategy(data, headers)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return errors.New("msg id can not be empty")
+	}
+	headers[msgIdHeader] = []string{id}
+	return nil
+}
+
+// ensureMsgId - makes sure the message has an id, so the broker drops it in case it is sent again.
+func ensureMsgId(msg *nats.Msg) {
+	if msg.Header.Get(msgIdHeader) != "" {
+		return
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return
+	}
+	msg.Header.Set(msgIdHeader, id.String())
+}
+
+// Producer.ProduceSync - produces a message and waits for the broker ack.
+// The result tells whether the broker has dropped the message as a duplicate of a message with the same id.
+func (p *Producer) ProduceSync(message any, opts ...ProduceOpt) (*ProduceResult, error) {
+	result, err := p.produceWithResult(message, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Producer.ProduceWithRetry - produces a message synchronously, retrying failed sends up to the given number of times
+// and waiting backoff between the attempts. All attempts use the same message id, so the broker keeps a single copy
+// of the message as long as the attempts are within the station's idempotency window.
+// Messages which fail before being sent, e.g. on schema validation, are not retried.
+func (p *Producer) ProduceWithRetry(ctx context.Context, message any, retries int, backoff time.Duration, opts ...ProduceOpt) (*ProduceResult, error) {
+	if retries < 0 {
+		return nil, memphisError(errors.New("retries can not be negative"))
+	}
+	var msgIdOpt ProduceOpt
+	for attempt := 0; ; attempt++ {
+		result, err := p.produceWithResult(message, append(opts, msgIdOpt)...)
+		if err == nil {
+			return result, nil
+		}
+		if result.MsgId == "" || attempt >= retries {
+			return nil, err
+		}
+		msgIdOpt = MsgId(result.MsgId)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, memphisError(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// produceWithResult - produces a message synchronously, the result holds the message id also when producing has failed after the id was set.
+func (p *Producer) produceWithResult(message any, opts ...ProduceOpt) (*ProduceResult, error) {
+	result := &ProduceResult{}
+	if p.isMultiStationProducer {
+		return result, memphisError(errors.New("produce results are not supported by multi station producers"))
+	}
+	opts = append(opts, func(o *ProduceOpts) error {
+		o.result = result
+		return nil
+	})
+	return result, p.produceToSingleStation(message, opts...)
+}
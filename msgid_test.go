@@ -0,0 +1,58 @@
+package memphis
+
+import (
+	"testing"
+)
+
+func TestMsgIdStrategies(t *testing.T) {
+	headers := map[string][]string{"tenant": {"a"}, "trace": {"1"}}
+	hash := MsgIdContentHash("tenant")
+	id1, err := hash([]byte("Hey There!"), headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, _ := hash([]byte("Hey There!"), map[string][]string{"tenant": {"a"}, "trace": {"2"}})
+	if id1 != id2 {
+		t.Error("content hash should only depend on the payload and the selected headers")
+	}
+	id3, _ := hash([]byte("Hey There!"), map[string][]string{"tenant": {"b"}})
+	if id1 == id3 {
+		t.Error("content hash should depend on the selected headers")
+	}
+
+	uuidv7 := MsgIdUUIDv7()
+	id4, _ := uuidv7(nil, nil)
+	id5, _ := uuidv7(nil, nil)
+	if id4 == "" || id4 == id5 {
+		t.Errorf("expected unique ids, got %q and %q", id4, id5)
+	}
+}
+
+func TestSetMsgId(t *testing.T) {
+	opts := getDefaultProducerOpts()
+	if err := AutoMsgId(func(data []byte, headers map[string][]string) (string, error) {
+		return "id_" + string(data), nil
+	})(&opts); err != nil {
+		t.Fatal(err)
+	}
+	p := &Producer{msgIdStrategy: opts.MsgIdStrategy}
+
+	headers := map[string][]string{}
+	if err := p.setMsgId([]byte("1"), headers); err != nil {
+		t.Fatal(err)
+	}
+	if id := headers[msgIdHeader]; len(id) != 1 || id[0] != "id_1" {
+		t.Errorf("unexpected msg id %v", id)
+	}
+
+	produceOpts := getDefaultProduceOpts()
+	if err := MsgId("user_id")(&produceOpts); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.setMsgId([]byte("1"), produceOpts.MsgHeaders.MsgHeaders); err != nil {
+		t.Fatal(err)
+	}
+	if id := produceOpts.MsgHeaders.MsgHeaders[msgIdHeader]; id[0] != "user_id" {
+		t.Errorf("the msg id set by the user should be kept, got %v", id)
+	}
+}
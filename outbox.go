@@ -0,0 +1,489 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	outboxSegmentExt          = ".seg"
+	outboxRecordHeaderSize    = 8
+	outboxDefaultSegmentBytes = 8 * 1024 * 1024
+	outboxDefaultMaxBytes     = 1024 * 1024 * 1024
+	outboxFsyncInterval       = time.Second
+	outboxHeadFile            = "head"
+	outboxHeadSize            = 16
+	outboxDeadLetterFile      = "dead_letter" + outboxSegmentExt
+)
+
+var (
+	ErrOutboxFull         = errors.New("outbox is full")
+	errOutboxDisconnected = errors.New("broker is disconnected")
+)
+
+// OutboxFsyncPolicy - when messages written to the outbox are flushed to disk.
+type OutboxFsyncPolicy int
+
+const (
+	// OutboxFsyncAlways - flush every message, the default.
+	OutboxFsyncAlways OutboxFsyncPolicy = iota
+	// OutboxFsyncInterval - flush at most once a second.
+	OutboxFsyncInterval
+	// OutboxFsyncNever - leave flushing to the operating system.
+	OutboxFsyncNever
+)
+
+// OutboxOpts - configuration options for the local outbox.
+type OutboxOpts struct {
+	Dir          string
+	FsyncPolicy  OutboxFsyncPolicy
+	MaxBytes     int64
+	SegmentBytes int64
+}
+
+type outboxRecord struct {
+	Subject string              `json:"subject"`
+	Headers map[string][]string `json:"headers"`
+	Data    []byte              `json:"data"`
+}
+
+// outbox - a disk backed log of messages which could not be sent to the broker, split into segment files.
+// Each record is written as its length, its crc32 and the json encoded message. The position of the next record to
+// replay is kept in the head file, so replayed messages are not sent again after a restart.
+type outbox struct {
+	mu           sync.Mutex
+	publish      func(msg *nats.Msg) error
+	opts         OutboxOpts
+	segments     []uint64
+	segmentSizes map[uint64]int64
+	active       *os.File
+	activeId     uint64
+	head         *os.File
+	headOffset   int64
+	totalBytes   int64
+	lastSync     time.Time
+	replaying    bool
+}
+
+func newOutbox(c *Conn, opts OutboxOpts) (*outbox, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, memphisError(err)
+	}
+	ob := &outbox{
+		opts:         opts,
+		segmentSizes: make(map[uint64]int64),
+	}
+	if c != nil {
+		ob.publish = func(msg *nats.Msg) error {
+			if !c.IsConnected() {
+				return errOutboxDisconnected
+			}
+			ctx, cancelfunc := context.WithTimeout(context.Background(), JetstreamOperationTimeout*time.Second)
+			defer cancelfunc()
+			_, err := c.js.PublishMsg(ctx, msg)
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, outboxSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, outboxSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		size, err := ob.recoverSegment(id)
+		if err != nil {
+			return nil, memphisError(err)
+		}
+		if size == 0 {
+			_ = os.Remove(ob.segmentPath(id))
+			continue
+		}
+		ob.segments = append(ob.segments, id)
+		ob.segmentSizes[id] = size
+		ob.totalBytes += size
+	}
+	sort.Slice(ob.segments, func(i, j int) bool { return ob.segments[i] < ob.segments[j] })
+	if len(ob.segments) > 0 {
+		ob.activeId = ob.segments[len(ob.segments)-1]
+	}
+	if err := ob.loadHead(); err != nil {
+		return nil, memphisError(err)
+	}
+
+	return ob, nil
+}
+
+// loadHead - skips the records of the first segment which were replayed before the outbox was closed.
+func (ob *outbox) loadHead() error {
+	var err error
+	ob.head, err = os.OpenFile(filepath.Join(ob.opts.Dir, outboxHeadFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, outboxHeadSize)
+	if n, _ := ob.head.ReadAt(head, 0); n != outboxHeadSize {
+		return nil
+	}
+	if len(ob.segments) == 0 {
+		// segment ids start over in an empty outbox, a head left by a crash must not skip records of the next segment
+		return ob.resetHead()
+	}
+	id, offset := binary.BigEndian.Uint64(head[:8]), int64(binary.BigEndian.Uint64(head[8:]))
+	if id != ob.segments[0] || offset > ob.segmentSizes[id] {
+		return nil
+	}
+	ob.headOffset = offset
+	ob.segmentSizes[id] -= offset
+	ob.totalBytes -= offset
+	return nil
+}
+
+// saveHead - stores the position of the next record to replay, should be called with the lock held.
+func (ob *outbox) saveHead(id uint64, offset int64) error {
+	head := make([]byte, outboxHeadSize)
+	binary.BigEndian.PutUint64(head[:8], id)
+	binary.BigEndian.PutUint64(head[8:], uint64(offset))
+	if _, err := ob.head.WriteAt(head, 0); err != nil {
+		return err
+	}
+	switch ob.opts.FsyncPolicy {
+	case OutboxFsyncAlways:
+		return ob.head.Sync()
+	case OutboxFsyncInterval:
+		if time.Since(ob.lastSync) >= outboxFsyncInterval {
+			ob.lastSync = time.Now()
+			return ob.head.Sync()
+		}
+	}
+	return nil
+}
+
+// resetHead - points the head at no segment and syncs it, so it never outlives the segment it pointed into.
+func (ob *outbox) resetHead() error {
+	if _, err := ob.head.WriteAt(make([]byte, outboxHeadSize), 0); err != nil {
+		return err
+	}
+	return ob.head.Sync()
+}
+
+func (ob *outbox) segmentPath(id uint64) string {
+	return filepath.Join(ob.opts.Dir, fmt.Sprintf("%020d%s", id, outboxSegmentExt))
+}
+
+// recoverSegment - returns the size of the valid records in a segment, truncating a partially written last record.
+func (ob *outbox) recoverSegment(id uint64) (int64, error) {
+	f, err := os.OpenFile(ob.segmentPath(id), os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var size int64
+	r := bufio.NewReader(f)
+	for {
+		_, n, err := readOutboxRecord(r)
+		if err != nil {
+			break
+		}
+		size += n
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != size {
+		if err := f.Truncate(size); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+func encodeOutboxRecord(rec *outboxRecord) []byte {
+	// a record holds only strings and bytes, so encoding it can not fail
+	payload, _ := json.Marshal(rec)
+	record := make([]byte, outboxRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:outboxRecordHeaderSize], crc32.ChecksumIEEE(payload))
+	copy(record[outboxRecordHeaderSize:], payload)
+	return record
+}
+
+func readOutboxRecord(r io.Reader) (*outboxRecord, int64, error) {
+	header := make([]byte, outboxRecordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("corrupted outbox record")
+	}
+	var rec outboxRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, 0, err
+	}
+	return &rec, int64(len(header) + len(payload)), nil
+}
+
+// pending - whether there are messages waiting to be sent, new messages have to wait behind them to keep the order.
+func (ob *outbox) pending() bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return len(ob.segments) > 0
+}
+
+func (ob *outbox) store(msg *nats.Msg) error {
+	record := encodeOutboxRecord(&outboxRecord{Subject: msg.Subject, Headers: msg.Header, Data: msg.Data})
+	var err error
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.totalBytes+int64(len(record)) > ob.opts.MaxBytes {
+		return ErrOutboxFull
+	}
+	if ob.active == nil {
+		ob.activeId++
+		ob.active, err = os.OpenFile(ob.segmentPath(ob.activeId), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return memphisError(err)
+		}
+		ob.segments = append(ob.segments, ob.activeId)
+		ob.segmentSizes[ob.activeId] = 0
+	}
+	if _, err := ob.active.Write(record); err != nil {
+		return memphisError(err)
+	}
+	ob.segmentSizes[ob.activeId] += int64(len(record))
+	ob.totalBytes += int64(len(record))
+
+	switch ob.opts.FsyncPolicy {
+	case OutboxFsyncAlways:
+		err = ob.active.Sync()
+	case OutboxFsyncInterval:
+		if time.Since(ob.lastSync) >= outboxFsyncInterval {
+			err = ob.active.Sync()
+			ob.lastSync = time.Now()
+		}
+	}
+	if err != nil {
+		return memphisError(err)
+	}
+
+	if ob.segmentSizes[ob.activeId] >= ob.opts.SegmentBytes {
+		ob.closeActive()
+	}
+	return nil
+}
+
+// closeActive - closes the segment open for writing, the next stored message opens a new segment.
+func (ob *outbox) closeActive() {
+	if ob.active == nil {
+		return
+	}
+	_ = ob.active.Sync()
+	_ = ob.active.Close()
+	ob.active = nil
+}
+
+// replay - sends the stored messages in order, stops on the first transient failure and resumes from it on the next replay.
+// Messages the broker can never accept are moved to the dead letter file, so they do not block the messages behind them.
+func (ob *outbox) replay() {
+	ob.mu.Lock()
+	if ob.replaying {
+		ob.mu.Unlock()
+		return
+	}
+	ob.replaying = true
+	ob.mu.Unlock()
+
+	for {
+		ob.mu.Lock()
+		if len(ob.segments) == 0 {
+			ob.replaying = false
+			ob.mu.Unlock()
+			return
+		}
+		id := ob.segments[0]
+		if ob.active != nil && ob.activeId == id {
+			ob.closeActive()
+		}
+		offset := ob.headOffset
+		ob.mu.Unlock()
+
+		err := ob.replaySegment(id, offset)
+		ob.mu.Lock()
+		if err == nil {
+			// segment ids start over when the outbox is reopened empty, so the head is reset before the segment is removed
+			err = ob.resetHead()
+		}
+		if err == nil {
+			err = os.Remove(ob.segmentPath(id))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			ob.replaying = false
+			ob.mu.Unlock()
+			log.Printf("outbox replay has stopped: %v", memphisError(err))
+			return
+		}
+		ob.segments = ob.segments[1:]
+		ob.totalBytes -= ob.segmentSizes[id]
+		delete(ob.segmentSizes, id)
+		ob.headOffset = 0
+		ob.mu.Unlock()
+	}
+}
+
+func (ob *outbox) replaySegment(id uint64, offset int64) error {
+	f, err := os.Open(ob.segmentPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readOutboxRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := ob.publish(&nats.Msg{Subject: rec.Subject, Header: rec.Headers, Data: rec.Data}); err != nil {
+			if !isPermanentPublishErr(err) {
+				return err
+			}
+			if err := ob.deadLetter(rec, err); err != nil {
+				return err
+			}
+		}
+
+		ob.mu.Lock()
+		ob.headOffset += n
+		ob.totalBytes -= n
+		ob.segmentSizes[id] -= n
+		err = ob.saveHead(id, ob.headOffset)
+		ob.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// outboxRejectedErrCodes - jetstream errors which reject the message itself, so sending it again can never succeed.
+var outboxRejectedErrCodes = map[jetstream.ErrorCode]bool{
+	jetstream.JSErrCodeBadRequest: true,
+	10054:                         true, // message size exceeds the maximum of the stream
+}
+
+// isPermanentPublishErr - whether the broker can never accept the message, unlike a disconnection or a timeout.
+// No responders and unavailable jetstream errors are transient, streams take a while to come back after a broker restart.
+func isPermanentPublishErr(err error) bool {
+	if errors.Is(err, nats.ErrMaxPayload) || errors.Is(err, nats.ErrBadSubject) {
+		return true
+	}
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.Code < 500 && outboxRejectedErrCodes[apiErr.ErrorCode]
+}
+
+// deadLetter - appends a message the broker rejected to the dead letter file of the outbox, which is never replayed.
+func (ob *outbox) deadLetter(rec *outboxRecord, reason error) error {
+	log.Printf("outbox message to %v was rejected and moved to the dead letter file: %v", rec.Subject, memphisError(reason))
+	f, err := os.OpenFile(filepath.Join(ob.opts.Dir, outboxDeadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(encodeOutboxRecord(rec)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (ob *outbox) close() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.closeActive()
+	_ = ob.head.Sync()
+	_ = ob.head.Close()
+}
+
+func (c *Conn) initOutbox() error {
+	if c.opts.OutboxOpts.Dir == "" {
+		return nil
+	}
+	ob, err := newOutbox(c, c.opts.OutboxOpts)
+	if err != nil {
+		return err
+	}
+	c.outbox = ob
+	if ob.pending() {
+		go ob.replay()
+	}
+	return nil
+}
+
+func (c *Conn) reconnectedHandler(nc *nats.Conn) {
+	if c.outbox != nil {
+		go c.outbox.replay()
+	}
+}
+
+// storeInOutbox - stores a message which could not be sent and kicks off a replay in case the broker is reachable.
+func (c *Conn) storeInOutbox(msg *nats.Msg) error {
+	if err := c.outbox.store(msg); err != nil {
+		return err
+	}
+	if c.IsConnected() {
+		go c.outbox.replay()
+	}
+	return nil
+}
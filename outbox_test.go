@@ -0,0 +1,188 @@
+package memphis
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestOutboxStoreAndRecover(t *testing.T) {
+	opts := OutboxOpts{Dir: t.TempDir(), FsyncPolicy: OutboxFsyncAlways, MaxBytes: 1024, SegmentBytes: 200}
+	ob, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &nats.Msg{Subject: "station$1.final", Header: nats.Header{}, Data: []byte("Hey There!")}
+	ensureMsgId(msg)
+	if msg.Header.Get(msgIdHeader) == "" {
+		t.Fatal("expected a msg id to be set")
+	}
+	for i := 0; i < 3; i++ {
+		if err := ob.store(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !ob.pending() {
+		t.Fatal("expected pending messages")
+	}
+	for {
+		if err := ob.store(msg); err != nil {
+			if err != ErrOutboxFull {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	stored := ob.totalBytes
+	ob.close()
+
+	// simulate a partially written record
+	lastSegment := ob.segmentPath(ob.segments[len(ob.segments)-1])
+	f, err := os.OpenFile(lastSegment, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	recovered, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.totalBytes != stored {
+		t.Errorf("expected %v recovered bytes, got %v", stored, recovered.totalBytes)
+	}
+	if len(recovered.segments) != len(ob.segments) {
+		t.Errorf("expected %v segments, got %v", len(ob.segments), len(recovered.segments))
+	}
+
+	f, err = os.Open(recovered.segmentPath(recovered.segments[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, _, err := readOutboxRecord(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Subject != msg.Subject || string(rec.Data) != string(msg.Data) || rec.Headers[msgIdHeader][0] != msg.Header.Get(msgIdHeader) {
+		t.Errorf("unexpected record %+v", rec)
+	}
+}
+
+func TestOutboxReplay(t *testing.T) {
+	opts := OutboxOpts{Dir: t.TempDir(), FsyncPolicy: OutboxFsyncAlways, MaxBytes: 1024 * 1024, SegmentBytes: 1024 * 1024}
+	ob, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"sent", "rejected", "transient"} {
+		if err := ob.store(&nats.Msg{Subject: "station$1.final", Header: nats.Header{}, Data: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var published []string
+	ob.publish = func(msg *nats.Msg) error {
+		switch string(msg.Data) {
+		case "rejected":
+			return &jetstream.APIError{Code: 400, ErrorCode: 10054, Description: "message size exceeds maximum allowed"}
+		case "transient":
+			return errOutboxDisconnected
+		}
+		published = append(published, string(msg.Data))
+		return nil
+	}
+	ob.replay()
+	if len(published) != 1 || published[0] != "sent" {
+		t.Errorf("expected only the first message to be sent, got %v", published)
+	}
+	if !ob.pending() {
+		t.Fatal("expected the replay to stop on a transient failure")
+	}
+	f, err := os.Open(filepath.Join(opts.Dir, outboxDeadLetterFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, _, err := readOutboxRecord(f)
+	f.Close()
+	if err != nil || string(rec.Data) != "rejected" {
+		t.Errorf("expected the rejected message in the dead letter file, got %+v %v", rec, err)
+	}
+	ob.close()
+
+	// the replayed messages are not sent again after a restart
+	reopened, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published = nil
+	reopened.publish = func(msg *nats.Msg) error {
+		published = append(published, string(msg.Data))
+		return nil
+	}
+	reopened.replay()
+	if len(published) != 1 || published[0] != "transient" {
+		t.Errorf("expected only the message which was not sent, got %v", published)
+	}
+	if reopened.pending() || reopened.totalBytes != 0 {
+		t.Errorf("expected an empty outbox, got %v bytes", reopened.totalBytes)
+	}
+	reopened.close()
+
+	for _, err := range []error{
+		jetstream.ErrNoStreamResponse,
+		errors.New("nats: timeout"),
+		&jetstream.APIError{Code: 503, ErrorCode: 10039, Description: "jetstream temporarily unavailable"},
+		&jetstream.APIError{Code: 500, ErrorCode: 10023, Description: "insufficient resources"},
+	} {
+		if isPermanentPublishErr(err) {
+			t.Errorf("expected %v to be transient", err)
+		}
+	}
+	if !isPermanentPublishErr(nats.ErrMaxPayload) || !isPermanentPublishErr(&jetstream.APIError{Code: 400, ErrorCode: jetstream.JSErrCodeBadRequest}) {
+		t.Error("expected rejected messages to be permanent errors")
+	}
+}
+
+func TestOutboxStaleHead(t *testing.T) {
+	opts := OutboxOpts{Dir: t.TempDir(), FsyncPolicy: OutboxFsyncAlways, MaxBytes: 1024 * 1024, SegmentBytes: 1024 * 1024}
+	ob, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash after a segment was removed while the head still pointed into it
+	if err := ob.saveHead(1, 10); err != nil {
+		t.Fatal(err)
+	}
+	ob.close()
+
+	reopened, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.store(&nats.Msg{Subject: "station$1.final", Header: nats.Header{}, Data: []byte("after the crash")}); err != nil {
+		t.Fatal(err)
+	}
+	reopened.close()
+
+	restarted, err := newOutbox(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published []string
+	restarted.publish = func(msg *nats.Msg) error {
+		published = append(published, string(msg.Data))
+		return nil
+	}
+	restarted.replay()
+	restarted.close()
+	if len(published) != 1 || published[0] != "after the crash" {
+		t.Errorf("expected the message stored after the crash to be replayed, got %v", published)
+	}
+}
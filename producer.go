@@ -21,8 +21,10 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -39,16 +41,97 @@ const (
 	lastProducerCreationReqVersion  = 4
 	schemaVerseDlsSubject           = "$memphis_schemaverse_dls"
 	lastProducerDestroyReqVersion   = 1
+	avroFormatHeader                = "$memphis_avro_format"
+	avroFormatBinary                = "binary"
+	deliverAtHeader                 = "$memphis_deliver_at"
+	partitionKeyHeader              = "$memphis_partition_key"
+	defaultAckWaitSec               = 15
 )
 
+// headersPool - header maps of produced messages are reused once the message was written to the connection,
+// or once its ack arrived when the ack is watched, since a message whose ack fails may still be stored in the outbox.
+var headersPool = sync.Pool{
+	New: func() any {
+		return make(map[string][]string, 4)
+	},
+}
+
+// AvroWireFormat - the format in which messages are sent to avro stations.
+type AvroWireFormat int
+
+const (
+	// AvroJSON - avro validated messages are sent as json, the default.
+	AvroJSON AvroWireFormat = iota
+	// AvroBinary - messages are sent in the avro binary encoding.
+	AvroBinary
+	// AvroSingleObject - messages are sent in the avro binary encoding, prefixed with the schema fingerprint.
+	AvroSingleObject
+)
+
+// MultiStationMode - how a multi station producer handles stations it can not produce to.
+type MultiStationMode int
+
+const (
+	// MultiStationReportPartial - produce to every station it can and report the stations which have failed, the default.
+	MultiStationReportPartial MultiStationMode = iota
+	// MultiStationAll - produce only if every station producer is ready and the message passes the schema of every station,
+	// failures while sending are still reported per station.
+	MultiStationAll
+)
+
+// MultiStationError - the stations a multi station produce has failed for, mapped to their errors.
+type MultiStationError struct {
+	Errors    map[string]error
+	Succeeded []string
+}
+
+func (e *MultiStationError) Error() string {
+	stationNames := make([]string, 0, len(e.Errors))
+	for stationName := range e.Errors {
+		stationNames = append(stationNames, stationName)
+	}
+	sort.Strings(stationNames)
+
+	failures := make([]string, len(stationNames))
+	for i, stationName := range stationNames {
+		failures[i] = fmt.Sprintf("%s: %v", stationName, e.Errors[stationName])
+	}
+	return fmt.Sprintf("produce has failed for %d station(s): %s", len(failures), strings.Join(failures, "; "))
+}
+
+func (e *MultiStationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
 // Producer - memphis producer object.
 type Producer struct {
-	Name                   string
-	stationName            interface{}
-	conn                   *Conn
-	realName               string
-	PartitionGenerator     *RoundRobinProducerConsumerGenerator
-	isMultiStationProducer bool
+	Name                     string
+	stationName              interface{}
+	conn                     *Conn
+	realName                 string
+	PartitionGenerator       *RoundRobinProducerConsumerGenerator
+	isMultiStationProducer   bool
+	multiStationMode         MultiStationMode
+	stationOpts              map[string]ProducerOpts
+	avroWireFormat           AvroWireFormat
+	rateLimiter              *tokenBucket
+	rateLimitPolicy          RateLimitPolicy
+	rateLimitHandler         RateLimitHandler
+	breaker                  *circuitBreaker
+	interceptors             []ProducerInterceptor
+	msgIdStrategy            MsgIdStrategy
+	validationPolicy         ValidationFailurePolicy
+	validationFailureHandler ValidationFailureHandler
+	internalStationName      string
+	schemaSub                *stationUpdateSub
+	subjects                 map[int]string
+	connIdHeader             []string
+	producedByHeader         []string
+	publishOpts              []jetstream.PublishOpt
 }
 
 type createProducerReq struct {
@@ -107,8 +190,22 @@ type removeProducerReq struct {
 
 // ProducerOpts - configuration options for producer creation.
 type ProducerOpts struct {
-	GenUniqueSuffix bool
-	TimeoutRetry    int
+	GenUniqueSuffix           bool
+	TimeoutRetry              int
+	AvroWireFormat            AvroWireFormat
+	RateLimitMsgsPerSec       float64
+	RateLimitBurst            int
+	RateLimitPolicy           RateLimitPolicy
+	RateLimitHandler          RateLimitHandler
+	CircuitBreakerThreshold   int
+	CircuitBreakerOpenTimeout time.Duration
+	CircuitStateHandler       CircuitStateHandler
+	MultiStationMode          MultiStationMode
+	StationOpts               map[string][]ProducerOpt
+	Interceptors              []ProducerInterceptor
+	MsgIdStrategy             MsgIdStrategy
+	ValidationPolicy          ValidationFailurePolicy
+	ValidationFailureHandler  ValidationFailureHandler
 }
 
 type Notification struct {
@@ -142,8 +239,11 @@ type ProducerOpt func(*ProducerOpts) error
 // getDefaultProducerOpts - returns default configuration options for producer creation.
 func getDefaultProducerOpts() ProducerOpts {
 	return ProducerOpts{
-		GenUniqueSuffix: false,
-		TimeoutRetry:    5,
+		GenUniqueSuffix:           false,
+		TimeoutRetry:              5,
+		AvroWireFormat:            AvroJSON,
+		RateLimitPolicy:           RateLimitBlock,
+		CircuitBreakerOpenTimeout: 30 * time.Second,
 	}
 }
 
@@ -190,12 +290,25 @@ func (c *Conn) CreateProducer(stationName interface{}, name string, opts ...Prod
 }
 
 func (c *Conn) createMultiStationProducer(stationNames []string, name, nameWithoutSuffix string, opts ProducerOpts) (*Producer, error) {
+	stationOpts := make(map[string]ProducerOpts, len(stationNames))
+	for _, stationName := range stationNames {
+		so := opts
+		for _, opt := range opts.StationOpts[stationName] {
+			if err := opt(&so); err != nil {
+				return nil, memphisError(err)
+			}
+		}
+		stationOpts[stationName] = so
+	}
+
 	return &Producer{
 		Name:                   name,
 		stationName:            stationNames,
 		conn:                   c,
 		realName:               nameWithoutSuffix,
 		isMultiStationProducer: true,
+		multiStationMode:       opts.MultiStationMode,
+		stationOpts:            stationOpts,
 	}, nil
 }
 
@@ -208,11 +321,17 @@ func (c *Conn) createSingleStationProducer(stationName, name, nameWithoutSuffix
 	}
 
 	p := Producer{
-		Name:        name,
-		stationName: stationName,
-		conn:        c,
-		realName:    nameWithoutSuffix,
+		Name:                     name,
+		stationName:              stationName,
+		conn:                     c,
+		realName:                 nameWithoutSuffix,
+		avroWireFormat:           opts.AvroWireFormat,
+		msgIdStrategy:            opts.MsgIdStrategy,
+		validationPolicy:         opts.ValidationPolicy,
+		validationFailureHandler: opts.ValidationFailureHandler,
 	}
+	p.initFlowControl(opts)
+	p.initInterceptors(opts)
 
 	sn := getInternalName(stationName)
 	_, ok := c.stationUpdatesSubs[sn]
@@ -227,6 +346,7 @@ func (c *Conn) createSingleStationProducer(stationName, name, nameWithoutSuffix
 	if err := c.create(&p, TimeoutRetry(opts.TimeoutRetry)); err != nil {
 		return nil, memphisError(err)
 	}
+	p.initProduceCache()
 	c.cacheProducer(&p)
 
 	err := c.listenToSchemaUpdates(stationName)
@@ -339,11 +459,12 @@ func (p *Producer) handleCreationResp(resp []byte) error {
 	p.conn.stationUpdatesMu.Lock()
 	sd := &p.conn.stationUpdatesSubs[sn].schemaDetails
 	sd.handleSchemaUpdateInit(cr.SchemaUpdateInit)
+	p.conn.stationUpdatesSubs[sn].storeSchema()
 	p.conn.stationUpdatesMu.Unlock()
 
-	p.conn.stationPartitions[sn] = &cr.PartitionsUpdate // length is 0 if its an old station
-	if len(p.conn.stationPartitions[sn].PartitionsList) != 0 {
-		pg := newRoundRobinGenerator(p.conn.stationPartitions[sn].PartitionsList)
+	p.conn.setStationPartitions(sn, &cr.PartitionsUpdate) // length is 0 if its an old station
+	if len(cr.PartitionsUpdate.PartitionsList) != 0 {
+		pg := newRoundRobinGenerator(cr.PartitionsUpdate.PartitionsList)
 		p.PartitionGenerator = pg
 	}
 
@@ -423,10 +544,6 @@ func (p *Producer) destroyMultiStationProducer(options ...RequestOpt) error {
 	return nil
 }
 
-type Headers struct {
-	MsgHeaders map[string][]string
-}
-
 // ProduceOpts - configuration options for produce operations.
 type ProduceOpts struct {
 	Message                 any
@@ -435,6 +552,9 @@ type ProduceOpts struct {
 	AsyncProduce            bool
 	ProducerPartitionKey    string
 	ProducerPartitionNumber int
+	DeliverAt               time.Time
+	TTL                     time.Duration
+	result                  *ProduceResult
 }
 
 // ProduceOpt - a function on the options for produce operations.
@@ -442,8 +562,8 @@ type ProduceOpt func(*ProduceOpts) error
 
 // getDefaultProduceOpts - returns default configuration options for produce operations.
 func getDefaultProduceOpts() ProduceOpts {
-	msgHeaders := make(map[string][]string)
-	return ProduceOpts{AckWaitSec: 15, MsgHeaders: Headers{MsgHeaders: msgHeaders}, AsyncProduce: true, ProducerPartitionKey: "", ProducerPartitionNumber: -1}
+	msgHeaders := headersPool.Get().(map[string][]string)
+	return ProduceOpts{AckWaitSec: defaultAckWaitSec, MsgHeaders: Headers{MsgHeaders: msgHeaders}, AsyncProduce: true, ProducerPartitionKey: "", ProducerPartitionNumber: -1}
 }
 
 // Producer.Produce - produces a message into a station. message is of type []byte/protoreflect.ProtoMessage in case it is a schema validated station
@@ -457,17 +577,94 @@ func (p *Producer) Produce(message any, opts ...ProduceOpt) error {
 
 func (p *Producer) produceToMultiStation(message any, opts ...ProduceOpt) error {
 	stationNames := p.stationName.([]string)
-
-	for _, station := range stationNames {
-		err := p.conn.Produce(station, p.Name, message, nil, opts)
+	msErr := &MultiStationError{Errors: make(map[string]error)}
+
+	// producers are resolved one by one since creating a producer updates connection state which is not safe for concurrent use
+	producers := make(map[string]*Producer, len(stationNames))
+	for _, stationName := range stationNames {
+		sp, err := p.stationProducer(stationName)
+		if err == nil && p.multiStationMode == MultiStationAll {
+			err = sp.checkMsg(message)
+		}
 		if err != nil {
-			return memphisError(err)
+			msErr.Errors[stationName] = memphisError(err)
+			continue
 		}
+		producers[stationName] = sp
+	}
+	if len(msErr.Errors) > 0 && p.multiStationMode == MultiStationAll {
+		return msErr
+	}
+
+	// every station gets its own copy of the headers since producing adds headers to them
+	opts = append(opts, func(o *ProduceOpts) error {
+		o.MsgHeaders.MsgHeaders = copyHeaders(o.MsgHeaders.MsgHeaders)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for stationName, sp := range producers {
+		wg.Add(1)
+		go func(stationName string, sp *Producer) {
+			defer wg.Done()
+			err := sp.produceToSingleStation(message, opts...)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				msErr.Errors[stationName] = err
+				return
+			}
+			msErr.Succeeded = append(msErr.Succeeded, stationName)
+		}(stationName, sp)
 	}
+	wg.Wait()
 
+	if len(msErr.Errors) > 0 {
+		sort.Strings(msErr.Succeeded)
+		return msErr
+	}
+	return nil
+}
+
+// stationProducer - returns the producer of a single station of a multi station producer, creating it when needed.
+func (p *Producer) stationProducer(stationName string) (*Producer, error) {
+	pn := fmt.Sprintf("%s_%s", getInternalName(stationName), p.realName)
+	pm := p.conn.getProducersMap()
+	if sp := pm.getProducer(pn); sp != nil {
+		return sp, nil
+	}
+	return p.conn.createSingleStationProducer(stationName, p.Name, p.realName, p.stationOpts[stationName])
+}
+
+// checkMsg - checks the message against the station schema without producing it.
+func (p *Producer) checkMsg(message any) error {
+	sd, err := p.getSchemaDetails()
+	if err != nil {
+		return errors.New("Schema validation has failed: " + err.Error())
+	}
+	if sd.schemaType == "" {
+		return nil
+	}
+	if sd.schemaType == "avro" && p.avroWireFormat != AvroJSON {
+		_, err = sd.avroBinaryMsg(message, p.avroWireFormat == AvroSingleObject)
+	} else {
+		_, err = sd.validateMsg(message)
+	}
+	if err != nil {
+		return errors.New("Schema validation has failed: " + err.Error())
+	}
 	return nil
 }
 
+func copyHeaders(headers map[string][]string) map[string][]string {
+	copied := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		copied[k] = append([]string(nil), v...)
+	}
+	return copied
+}
+
 func (p *Producer) produceToSingleStation(message any, opts ...ProduceOpt) error {
 	defaultOpts := getDefaultProduceOpts()
 	defaultOpts.Message = message
@@ -483,110 +680,196 @@ func (p *Producer) produceToSingleStation(message any, opts ...ProduceOpt) error
 	return defaultOpts.produce(p)
 }
 
-func (hdr *Headers) validateHeaderKey(key string) error {
-	if strings.HasPrefix(key, "$memphis") {
-		return memphisError(errors.New("keys in headers should not start with $memphis"))
+// ProducerOpts.produce - produces a message into a station using a configuration struct.
+func (opts *ProduceOpts) produce(p *Producer) error {
+	message := opts.Message
+	var im *InterceptedMsg
+	if len(p.interceptors) > 0 {
+		im = &InterceptedMsg{Message: opts.Message, Headers: &opts.MsgHeaders}
+		if err := p.beforeProduce(im); err != nil {
+			return err
+		}
+		message = im.Message
 	}
-	return nil
-}
 
-func (hdr *Headers) New() {
-	hdr.MsgHeaders = map[string][]string{}
-}
+	headers := opts.MsgHeaders.MsgHeaders
+	headers["$memphis_connectionId"] = p.connIdHeader
+	headers["$memphis_producedBy"] = p.producedByHeader
+	if !opts.DeliverAt.IsZero() && opts.DeliverAt.After(time.Now()) {
+		headers[deliverAtHeader] = []string{strconv.FormatInt(opts.DeliverAt.UnixMilli(), 10)}
+	}
+	if opts.ProducerPartitionKey != "" {
+		headers[partitionKeyHeader] = []string{opts.ProducerPartitionKey}
+	}
+	if opts.TTL > 0 {
+		headers[expiresAtHeader] = []string{strconv.FormatInt(time.Now().Add(opts.TTL).UnixMilli(), 10)}
+	}
 
-func (hdr *Headers) Add(key, value string) error {
-	err := hdr.validateHeaderKey(key)
+	data, err := p.validateMsg(message, headers)
+	if err == errSentToDls {
+		return nil
+	}
+	var vErr *SchemaValidationError
+	if errors.As(err, &vErr) {
+		return err
+	}
 	if err != nil {
 		return memphisError(err)
 	}
+	if err := p.setMsgId(data, headers); err != nil {
+		return memphisError(err)
+	}
 
-	hdr.MsgHeaders[key] = []string{value}
-	return nil
-}
-
-// ProducerOpts.produce - produces a message into a station using a configuration struct.
-func (opts *ProduceOpts) produce(p *Producer) error {
-	opts.MsgHeaders.MsgHeaders["$memphis_connectionId"] = []string{p.conn.ConnId}
-	opts.MsgHeaders.MsgHeaders["$memphis_producedBy"] = []string{p.Name}
-
-	data, err := p.validateMsg(opts.Message, opts.MsgHeaders.MsgHeaders)
+	partition, err := p.partition(opts)
 	if err != nil {
 		return memphisError(err)
 	}
 
-	var streamName string
-	sn := getInternalName(p.stationName.(string))
-
-	if len(p.conn.stationPartitions[sn].PartitionsList) == 1 {
-		streamName = fmt.Sprintf("%v$%v", sn, p.conn.stationPartitions[sn].PartitionsList[0])
-	} else if len(p.conn.stationPartitions[sn].PartitionsList) > 1 {
-		if opts.ProducerPartitionNumber > 0 && opts.ProducerPartitionKey != "" {
-			return memphisError(fmt.Errorf("Can not use both partition number and partition key"))
-		}
-		if opts.ProducerPartitionKey != "" {
-			partitionNumber, err := p.conn.GetPartitionFromKey(opts.ProducerPartitionKey, sn)
-			if err != nil {
-				return memphisError(fmt.Errorf("failed to get partition from key"))
-			}
-			streamName = fmt.Sprintf("%v$%v", sn, partitionNumber)
-		} else if opts.ProducerPartitionNumber > 0 {
-			err := p.conn.ValidatePartitionNumber(opts.ProducerPartitionNumber, sn)
-			if err != nil {
-				return memphisError(err)
-			}
-			streamName = fmt.Sprintf("%v$%v", sn, opts.ProducerPartitionNumber)
-		} else {
-			partitionNumber := p.PartitionGenerator.Next()
-			streamName = fmt.Sprintf("%v$%v", sn, partitionNumber)
-		}
-	} else {
-		streamName = sn
+	natsMessage := nats.Msg{
+		Header:  headers,
+		Subject: p.subject(partition),
+		Data:    data,
 	}
 
-	var fullSubjectName string
-	if functionsMap, ok := p.conn.stationFunctionSubs[sn]; ok {
-		partitionNumber, err := strconv.Atoi(strings.Split(streamName, "$")[1])
-
-		functionsMap.StationFunctionsMu.RLock()
+	if p.conn.outbox != nil || opts.result != nil {
+		ensureMsgId(&natsMessage)
+	}
+	if opts.result != nil {
+		opts.result.MsgId = natsMessage.Header.Get(msgIdHeader)
+	}
 
-		if err != nil {
-			return memphisError(err)
-		}
-		if funcID, ok := functionsMap.FunctionsDetails.PartitionsFunctions[partitionNumber]; ok {
-			fullSubjectName = fmt.Sprintf("%v.functions.%v", streamName, funcID)
-		} else {
-			fullSubjectName = streamName + ".final"
+	if p.conn.outbox != nil {
+		if !p.conn.IsConnected() || p.conn.outbox.pending() {
+			return p.conn.storeInOutbox(&natsMessage)
 		}
-
-		functionsMap.StationFunctionsMu.RUnlock()
-	} else {
-		fullSubjectName = streamName + ".final"
 	}
 
-	natsMessage := nats.Msg{
-		Header:  opts.MsgHeaders.MsgHeaders,
-		Subject: fullSubjectName,
-		Data:    data,
+	if err := p.admit(); err != nil {
+		return err
 	}
 
 	stallWaitDuration := time.Second * time.Duration(opts.AckWaitSec)
-	paf, err := p.conn.brokerPublish(&natsMessage, jetstream.WithStallWait(stallWaitDuration))
+	publishOpts := p.publishOpts
+	if opts.AckWaitSec != defaultAckWaitSec {
+		publishOpts = []jetstream.PublishOpt{jetstream.WithStallWait(stallWaitDuration)}
+	}
+	paf, err := p.conn.brokerPublish(&natsMessage, publishOpts...)
 	if err != nil {
+		p.recordPublishResult(err)
+		if p.conn.outbox != nil {
+			return p.conn.storeInOutbox(&natsMessage)
+		}
+		p.afterAck(im, nil, err)
 		return memphisError(err)
 	}
 
-	if opts.AsyncProduce {
+	if opts.AsyncProduce && opts.result == nil {
+		if p.breaker != nil || p.conn.outbox != nil || len(p.interceptors) > 0 {
+			go p.watchAck(paf, &natsMessage, im, stallWaitDuration)
+			return nil
+		}
+		// the headers were encoded when the message was written, nothing reads them anymore
+		p.releaseHeaders(headers)
 		return nil
 	}
 
 	select {
-	case <-paf.Ok():
+	case ack := <-paf.Ok():
+		p.recordPublishResult(nil)
+		if opts.result != nil {
+			opts.result.Stream = ack.Stream
+			opts.result.Sequence = ack.Sequence
+			opts.result.Duplicate = ack.Duplicate
+		}
+		p.afterAck(im, ack, nil)
+		p.releaseHeaders(headers)
 		return nil
 	case err = <-paf.Err():
+		p.recordPublishResult(err)
+		if p.conn.outbox != nil {
+			err = p.conn.storeInOutbox(&natsMessage)
+			p.releaseHeaders(headers)
+			return err
+		}
+		p.afterAck(im, nil, err)
+		p.releaseHeaders(headers)
 		return memphisError(err)
 	}
 }
 
+// initProduceCache - precomputes what every produce needs, once the station partitions are known.
+func (p *Producer) initProduceCache() {
+	sn := getInternalName(p.stationName.(string))
+	p.internalStationName = sn
+	p.schemaSub = p.conn.stationUpdatesSubs[sn]
+	p.connIdHeader = []string{p.conn.ConnId}
+	p.producedByHeader = []string{p.Name}
+	p.publishOpts = []jetstream.PublishOpt{jetstream.WithStallWait(defaultAckWaitSec * time.Second)}
+
+	p.subjects = make(map[int]string)
+	if partitions := p.conn.stationPartitionsList(sn); len(partitions) > 0 {
+		for _, partition := range partitions {
+			p.subjects[partition] = sn + "$" + strconv.Itoa(partition) + ".final"
+		}
+	} else {
+		p.subjects[0] = sn + ".final"
+	}
+}
+
+// partition - the partition a message is produced to, 0 for stations without partitions.
+func (p *Producer) partition(opts *ProduceOpts) (int, error) {
+	sn := p.internalStationName
+	partitions := p.conn.stationPartitionsList(sn)
+	if len(partitions) == 0 {
+		return 0, nil
+	}
+	if len(partitions) == 1 {
+		return partitions[0], nil
+	}
+
+	if opts.ProducerPartitionNumber > 0 && opts.ProducerPartitionKey != "" {
+		return 0, fmt.Errorf("Can not use both partition number and partition key")
+	}
+	if opts.ProducerPartitionKey != "" {
+		partitionNumber, err := p.conn.GetPartitionFromKey(opts.ProducerPartitionKey, sn)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get partition from key")
+		}
+		return partitionNumber, nil
+	}
+	if opts.ProducerPartitionNumber > 0 {
+		if err := p.conn.ValidatePartitionNumber(opts.ProducerPartitionNumber, sn); err != nil {
+			return 0, err
+		}
+		return opts.ProducerPartitionNumber, nil
+	}
+	return p.PartitionGenerator.Next(), nil
+}
+
+// subject - the subject of messages to a partition, a function of the partition gets the messages first.
+func (p *Producer) subject(partition int) string {
+	if sfs, ok := p.conn.stationFunctionSubs[p.internalStationName]; ok {
+		if subject, ok := sfs.subject(partition); ok {
+			return subject
+		}
+	}
+	if subject, ok := p.subjects[partition]; ok {
+		return subject
+	}
+	return p.internalStationName + "$" + strconv.Itoa(partition) + ".final"
+}
+
+// releaseHeaders - returns the header map of an acked message to the pool, unless interceptors may still hold it.
+func (p *Producer) releaseHeaders(headers map[string][]string) {
+	if len(p.interceptors) > 0 {
+		return
+	}
+	for k := range headers {
+		delete(headers, k)
+	}
+	headersPool.Put(headers)
+}
+
 func (p *Producer) sendNotification(title string, msg string, code string, msgType string) {
 	notification := Notification{
 		Title: title,
@@ -614,31 +897,51 @@ func (p *Producer) msgToString(msg any) string {
 func (p *Producer) sendMsgToDls(msg any, headers map[string][]string, err error) {
 	internStation := getInternalName(p.stationName.(string))
 	if p.conn.clientsUpdatesSub.StationSchemaverseToDlsMap[internStation] {
-		msgToSend := p.msgToString(msg)
-		headersForDls := make(map[string]string)
-		for k, v := range headers {
-			concat := strings.Join(v, " ")
-			headersForDls[k] = concat
-		}
-		schemaFailMsg := &DlsMessage{
-			StationName: internStation,
-			Producer: ProducerDetails{
-				Name:         p.Name,
-				ConnectionId: p.conn.ConnId,
-			},
-			Message: MessagePayloadDls{
-				Data:    hex.EncodeToString([]byte(msgToSend)),
-				Headers: headersForDls,
-			},
-			ValidationError: err.Error(),
-		}
-		msgToPublish, _ := json.Marshal(schemaFailMsg)
-		_ = p.conn.brokerConn.Publish(schemaVerseDlsSubject, msgToPublish)
+		p.publishMsgToDls(msg, headers, err)
+	}
+}
+
+func (p *Producer) publishMsgToDls(msg any, headers map[string][]string, err error) {
+	internStation := getInternalName(p.stationName.(string))
+	msgToSend := p.msgToString(msg)
+	headersForDls := make(map[string]string)
+	for k, v := range headers {
+		concat := strings.Join(v, " ")
+		headersForDls[k] = concat
+	}
+	schemaFailMsg := &DlsMessage{
+		StationName: internStation,
+		Producer: ProducerDetails{
+			Name:         p.Name,
+			ConnectionId: p.conn.ConnId,
+		},
+		Message: MessagePayloadDls{
+			Data:    hex.EncodeToString([]byte(msgToSend)),
+			Headers: headersForDls,
+		},
+		ValidationError: err.Error(),
+	}
+	msgToPublish, _ := json.Marshal(schemaFailMsg)
+	_ = p.conn.brokerConn.Publish(schemaVerseDlsSubject, msgToPublish)
 
-		if p.conn.clientsUpdatesSub.ClusterConfigurations["send_notification"] {
-			p.sendNotification("Schema validation has failed", "Station: "+p.stationName.(string)+"\nProducer: "+p.Name+"\nError: "+err.Error(), msgToSend, schemaVFailAlertType)
+	if p.conn.clientsUpdatesSub.ClusterConfigurations["send_notification"] {
+		p.sendNotification("Schema validation has failed", "Station: "+p.stationName.(string)+"\nProducer: "+p.Name+"\nError: "+err.Error(), msgToSend, schemaVFailAlertType)
+	}
+}
+
+// coerceMsg - fixes a message which has failed schema validation, encoding it in the producer's avro wire format.
+func (p *Producer) coerceMsg(sd *schemaDetails, msg any, headers map[string][]string) ([]byte, error) {
+	msgBytes, err := sd.coerceMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+	if sd.schemaType == "avro" && p.avroWireFormat != AvroJSON {
+		if msgBytes, err = sd.avroBinaryMsg(msgBytes, p.avroWireFormat == AvroSingleObject); err != nil {
+			return nil, err
 		}
+		headers[avroFormatHeader] = []string{avroFormatBinary}
 	}
+	return msgBytes, nil
 }
 
 func (p *Producer) validateMsg(msg any, headers map[string][]string) ([]byte, error) {
@@ -680,15 +983,41 @@ func (p *Producer) validateMsg(msg any, headers map[string][]string) ([]byte, er
 
 	// empty schema type means there is no schema and validation is not needed
 	if sd.schemaType != "" {
-		msgBytes, err := sd.validateMsg(msg)
+		var msgBytes []byte
+		if sd.schemaType == "avro" && p.avroWireFormat != AvroJSON {
+			msgBytes, err = sd.avroBinaryMsg(msg, p.avroWireFormat == AvroSingleObject)
+			if err == nil {
+				headers[avroFormatHeader] = []string{avroFormatBinary}
+			}
+		} else {
+			msgBytes, err = sd.validateMsg(msg)
+		}
 		if err != nil {
+			vErr := &SchemaValidationError{
+				StationName:   p.stationName.(string),
+				SchemaName:    sd.name,
+				SchemaType:    sd.schemaType,
+				SchemaVersion: sd.activeVersion.VersionNumber,
+				Err:           memphisError(err),
+			}
+			if p.validationPolicy == ValidationCoerce {
+				if coerced, cErr := p.coerceMsg(sd, msg, headers); cErr == nil {
+					p.onValidationFailure(msg, headers, vErr, true)
+					return coerced, nil
+				}
+			}
+			p.onValidationFailure(msg, headers, vErr, false)
+
 			msgToSend := originalMsgBytes
 			if msgBytes != nil {
 				msgToSend = msgBytes
 			}
-
+			if p.validationPolicy == ValidationDeadLetter {
+				p.publishMsgToDls(msgToSend, headers, err)
+				return nil, errSentToDls
+			}
 			p.sendMsgToDls(msgToSend, headers, err)
-			return nil, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+			return nil, vErr
 		}
 		originalMsgBytes = msgBytes
 	}
@@ -696,8 +1025,12 @@ func (p *Producer) validateMsg(msg any, headers map[string][]string) ([]byte, er
 	return originalMsgBytes, nil
 }
 
-func (p *Producer) getSchemaDetails() (schemaDetails, error) {
-	return p.conn.getSchemaDetails(p.stationName.(string))
+func (p *Producer) getSchemaDetails() (*schemaDetails, error) {
+	if p.schemaSub != nil {
+		return p.schemaSub.schema(), nil
+	}
+	sd, err := p.conn.getSchemaDetails(p.stationName.(string))
+	return &sd, err
 }
 
 // Deprecated: will be stopped to be supported after November 1'st, 2023.
@@ -737,7 +1070,12 @@ func ProducerPartitionNumber(partitionNumber int) ProduceOpt {
 // MsgHeaders - set headers to a message
 func MsgHeaders(hdrs Headers) ProduceOpt {
 	return func(opts *ProduceOpts) error {
-		opts.MsgHeaders = hdrs
+		for key := range hdrs.MsgHeaders {
+			if err := validateHeaderKey(key); err != nil {
+				return err
+			}
+		}
+		opts.MsgHeaders = hdrs.Clone()
 		return nil
 	}
 }
@@ -758,13 +1096,161 @@ func SyncProduce() ProduceOpt {
 	}
 }
 
+// DeliverAt - hide the message from consumers until the given time.
+// Consumers redeliver messages which are not due yet with a delay, which takes one extra delivery of the message.
+func DeliverAt(deliverAt time.Time) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		opts.DeliverAt = deliverAt
+		return nil
+	}
+}
+
+// DeliverAfter - hide the message from consumers until the given duration has passed.
+func DeliverAfter(delay time.Duration) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if delay < 0 {
+			return errors.New("delivery delay can not be negative")
+		}
+		opts.DeliverAt = time.Now().Add(delay)
+		return nil
+	}
+}
+
+// TTL - the message expires after the given duration from produce, consumers handle expired messages according to their ExpiredPolicy.
+func TTL(ttl time.Duration) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if ttl <= 0 {
+			return errors.New("ttl has to be positive")
+		}
+		opts.TTL = ttl
+		return nil
+	}
+}
+
 // MsgId - set an id for a message for idempotent producer
 func MsgId(id string) ProduceOpt {
 	return func(opts *ProduceOpts) error {
 		if id == "" {
 			return errors.New("msg id can not be empty")
 		}
-		opts.MsgHeaders.MsgHeaders["msg-id"] = []string{id}
+		opts.MsgHeaders.MsgHeaders[msgIdHeader] = []string{id}
+		return nil
+	}
+}
+
+// AutoMsgId - set an id on every message which has none, using the given strategy, so the broker drops duplicates
+// within the station's idempotency window.
+func AutoMsgId(strategy MsgIdStrategy) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if strategy == nil {
+			return errors.New("msg id strategy can not be nil")
+		}
+		opts.MsgIdStrategy = strategy
+		return nil
+	}
+}
+
+// OnValidationFailure - set a handler called for every message which fails schema validation.
+func OnValidationFailure(handler ValidationFailureHandler) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.ValidationFailureHandler = handler
+		return nil
+	}
+}
+
+// ProducerValidationPolicy - set what the producer does with messages which fail schema validation, default is ValidationReject.
+func ProducerValidationPolicy(policy ValidationFailurePolicy) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.ValidationPolicy = policy
+		return nil
+	}
+}
+
+// ProducerAvroWireFormat - the format in which messages are sent to avro stations, default is AvroJSON.
+// Consumers detect binary messages by their headers, so stations may contain both formats.
+func ProducerAvroWireFormat(format AvroWireFormat) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.AvroWireFormat = format
+		return nil
+	}
+}
+
+// RateLimit - limit the rate of produced messages using a token bucket of the given burst size.
+func RateLimit(msgsPerSec float64, burst int) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if msgsPerSec <= 0 {
+			return errors.New("rate limit has to be a positive number")
+		}
+		if burst < 1 {
+			return errors.New("rate limit burst has to be at least 1")
+		}
+		opts.RateLimitMsgsPerSec = msgsPerSec
+		opts.RateLimitBurst = burst
+		return nil
+	}
+}
+
+// RateLimitPolicyOpt - whether to block or drop messages exceeding the rate limit, default is RateLimitBlock.
+func RateLimitPolicyOpt(policy RateLimitPolicy) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.RateLimitPolicy = policy
+		return nil
+	}
+}
+
+// ProducerRateLimitHandler - called when a message exceeds the rate limit.
+func ProducerRateLimitHandler(handler RateLimitHandler) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.RateLimitHandler = handler
+		return nil
+	}
+}
+
+// CircuitBreaker - stop producing after failureThreshold consecutive publish or ack failures and fail fast with ErrCircuitOpen,
+// after openTimeout a single probe message is let through to check whether the broker has recovered.
+func CircuitBreaker(failureThreshold int, openTimeout time.Duration) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if failureThreshold < 1 {
+			return errors.New("circuit breaker failure threshold has to be at least 1")
+		}
+		opts.CircuitBreakerThreshold = failureThreshold
+		opts.CircuitBreakerOpenTimeout = openTimeout
+		return nil
+	}
+}
+
+// ProducerCircuitStateHandler - called when the producer circuit breaker changes its state.
+func ProducerCircuitStateHandler(handler CircuitStateHandler) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.CircuitStateHandler = handler
+		return nil
+	}
+}
+
+// ProducerMultiStationMode - set how a multi station producer handles stations it can not produce to.
+func ProducerMultiStationMode(mode MultiStationMode) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.MultiStationMode = mode
+		return nil
+	}
+}
+
+// StationProducerOpts - options applied only to the producer of the given station of a multi station producer,
+// on top of the options of the multi station producer.
+func StationProducerOpts(stationName string, stationOpts ...ProducerOpt) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if opts.StationOpts == nil {
+			opts.StationOpts = make(map[string][]ProducerOpt)
+		}
+		opts.StationOpts[stationName] = append(opts.StationOpts[stationName], stationOpts...)
+		return nil
+	}
+}
+
+// ProducerInterceptors - add interceptors which run in order on every message of the producer, after the connection's default interceptors.
+func ProducerInterceptors(interceptors ...ProducerInterceptor) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.Interceptors = append(opts.Interceptors, interceptors...)
 		return nil
 	}
 }
@@ -2,8 +2,12 @@ package memphis
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/nats-io/nats.go"
 )
 
 func TestCreateProducer(t *testing.T) {
@@ -361,3 +365,93 @@ func TestFullFlow(t *testing.T) {
 		t.Errorf("Consumer destruction failed: %v\n", err)
 	}
 }
+
+func TestDeliveryDelay(t *testing.T) {
+	opts := getDefaultProduceOpts()
+	if err := DeliverAfter(10 * time.Minute)(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeliverAfter(-time.Second)(&opts); err == nil {
+		t.Error("expected error for a negative delay")
+	}
+
+	now := time.Now()
+	msg := &Msg{msg: &nats.Msg{Header: nats.Header{deliverAtHeader: []string{strconv.FormatInt(opts.DeliverAt.UnixMilli(), 10)}}}}
+	if delay := msg.deliveryDelay(now); delay < 9*time.Minute || delay > 10*time.Minute {
+		t.Errorf("unexpected delivery delay %v", delay)
+	}
+
+	noDelayMsg := &Msg{msg: &nats.Msg{}}
+	if delay := noDelayMsg.deliveryDelay(now); delay != 0 {
+		t.Errorf("unexpected delivery delay %v", delay)
+	}
+}
+
+func TestDeferNotDueMsgs(t *testing.T) {
+	c := &Consumer{MaxMsgDeliveries: 3}
+	deliverAt := nats.Header{deliverAtHeader: []string{strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)}}
+	due := &testAckMsg{numDelivered: 1}
+	notDue := &testAckMsg{testJsMsg: testJsMsg{headers: deliverAt}, numDelivered: 1}
+
+	msgs := c.deferNotDueMsgs([]*Msg{{msg: due}, {msg: notDue}})
+	if len(msgs) != 1 || msgs[0].msg != due || notDue.nakDelay < 59*time.Minute {
+		t.Errorf("expected the message which is not due to be deferred, got %v messages", len(msgs))
+	}
+
+	// deferred more than once, the last delivery is not spent on another deferral
+	notDue.nakDelay = 0
+	notDue.numDelivered = 2
+	if msgs := c.deferNotDueMsgs([]*Msg{{msg: notDue}}); len(msgs) != 0 || notDue.nakDelay == 0 {
+		t.Error("expected the message to be deferred again while it has deliveries left")
+	}
+	notDue.nakDelay = 0
+	notDue.numDelivered = 3
+	if msgs := c.deferNotDueMsgs([]*Msg{{msg: notDue}}); len(msgs) != 1 || notDue.nakDelay != 0 {
+		t.Error("expected the message to be passed on on its last delivery")
+	}
+}
+
+func TestMultiStationProducerOpts(t *testing.T) {
+	c := &Conn{}
+	opts := getDefaultProducerOpts()
+	for _, opt := range []ProducerOpt{
+		ProducerMultiStationMode(MultiStationAll),
+		ProducerAvroWireFormat(AvroBinary),
+		StationProducerOpts("station_b", ProducerAvroWireFormat(AvroSingleObject)),
+	} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := c.createMultiStationProducer([]string{"station_a", "station_b"}, "producer", "producer", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.multiStationMode != MultiStationAll {
+		t.Errorf("unexpected multi station mode %v", p.multiStationMode)
+	}
+	if f := p.stationOpts["station_a"].AvroWireFormat; f != AvroBinary {
+		t.Errorf("station_a: unexpected avro wire format %v", f)
+	}
+	if f := p.stationOpts["station_b"].AvroWireFormat; f != AvroSingleObject {
+		t.Errorf("station_b: unexpected avro wire format %v", f)
+	}
+}
+
+func TestMultiStationError(t *testing.T) {
+	err := error(&MultiStationError{
+		Errors:    map[string]error{"station_b": ErrCircuitOpen, "station_a": errors.New("timeout")},
+		Succeeded: []string{"station_c"},
+	})
+	if msg := err.Error(); msg != "produce has failed for 2 station(s): station_a: timeout; station_b: producer circuit breaker is open" {
+		t.Errorf("unexpected error message %q", msg)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Error("expected the station errors to be wrapped")
+	}
+	var msErr *MultiStationError
+	if !errors.As(err, &msErr) || len(msErr.Succeeded) != 1 {
+		t.Error("expected a MultiStationError")
+	}
+}
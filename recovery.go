@@ -0,0 +1,201 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// SubscriptionState - the state of the consumer subscription to the station, reported when auto recovery is enabled.
+type SubscriptionState int
+
+const (
+	// SubscriptionActive - the station is reachable, after creation and once the consumer was recovered.
+	SubscriptionActive SubscriptionState = iota
+	// SubscriptionRecovering - the station is unreachable and the consumer is being recreated.
+	SubscriptionRecovering
+	// SubscriptionFailed - the consumer could not be recreated within the max attempts and stopped consuming.
+	SubscriptionFailed
+)
+
+func (s SubscriptionState) String() string {
+	return [...]string{"active", "recovering", "failed"}[s]
+}
+
+// SubscriptionStateHandler - called on every change of the subscription state, with the error which caused it.
+type SubscriptionStateHandler func(c *Consumer, state SubscriptionState, err error)
+
+// consumerRecovery - recreates a consumer whose station became unreachable, with the backoff of a retry policy.
+type consumerRecovery struct {
+	policy       RetryPolicy
+	stateHandler SubscriptionStateHandler
+	rebind       func() error
+	mu           sync.Mutex
+	state        SubscriptionState
+	quit         chan struct{}
+	quitOnce     sync.Once
+}
+
+func newConsumerRecovery(policy RetryPolicy, stateHandler SubscriptionStateHandler, rebind func() error) *consumerRecovery {
+	return &consumerRecovery{
+		policy:       policy,
+		stateHandler: stateHandler,
+		rebind:       rebind,
+		state:        SubscriptionActive,
+		quit:         make(chan struct{}),
+	}
+}
+
+// stationUnreachable - marks the subscription inactive and reports the station as unreachable. Returns whether the
+// consumer is being recovered, in which case consuming goes on once the subscription is active again.
+func (c *Consumer) stationUnreachable(err error) bool {
+	c.subscriptionActive.Store(false)
+	c.callErrHandler(ConsumerErrStationUnreachable)
+	if c.recovery == nil {
+		return false
+	}
+	return c.recovery.start(c, err)
+}
+
+// restartStreams - restarts the streams of a streaming consume, so they use the recovered jetstream consumers.
+func (c *Consumer) restartStreams() error {
+	c.lifecycleMu.Lock()
+	run := c.run
+	c.lifecycleMu.Unlock()
+	if run == nil || run.streams == nil {
+		return nil
+	}
+	return run.streams.restart()
+}
+
+// rebind - reissues the consumer creation request, which recreates the station and the consumer group in case they
+// were removed, and looks up the jetstream consumers of the partitions again.
+func (c *Consumer) rebind(options ...RequestOpt) error {
+	if err := c.conn.create(c, options...); err != nil {
+		return err
+	}
+	jsConsumers, err := c.bindJsConsumers()
+	if err != nil {
+		return err
+	}
+	c.jsConsumersMu.Lock()
+	c.jsConsumers = jsConsumers
+	c.jsConsumersMu.Unlock()
+	return nil
+}
+
+// start - starts recovering the consumer unless it is recovered already, returns false once the recovery failed.
+func (r *consumerRecovery) start(c *Consumer, err error) bool {
+	select {
+	case <-r.quit:
+		return true
+	default:
+	}
+	r.mu.Lock()
+	switch r.state {
+	case SubscriptionFailed:
+		r.mu.Unlock()
+		return false
+	case SubscriptionRecovering:
+		r.mu.Unlock()
+		return true
+	}
+	r.state = SubscriptionRecovering
+	r.mu.Unlock()
+
+	r.notify(c, SubscriptionRecovering, err)
+	go r.recover(c)
+	return true
+}
+
+// recover - recreates the consumer until it succeeds, the max attempts are reached or the consumer is destroyed.
+// Once the max attempts are reached consuming is stopped.
+func (r *consumerRecovery) recover(c *Consumer) {
+	for attempt := 1; ; attempt++ {
+		err := r.rebind()
+		if err == nil {
+			err = c.restartStreams()
+		}
+		if err == nil {
+			c.subscriptionActive.Store(true)
+			r.setState(SubscriptionActive)
+			r.notify(c, SubscriptionActive, nil)
+			return
+		}
+
+		if r.policy.MaxAttempts > 0 && attempt >= r.policy.MaxAttempts {
+			r.setState(SubscriptionFailed)
+			r.notify(c, SubscriptionFailed, memphisError(err))
+			c.requestStop()
+			return
+		}
+
+		timer := time.NewTimer(r.policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-r.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (r *consumerRecovery) setState(state SubscriptionState) {
+	r.mu.Lock()
+	r.state = state
+	r.mu.Unlock()
+}
+
+func (r *consumerRecovery) notify(c *Consumer, state SubscriptionState, err error) {
+	if r.stateHandler != nil {
+		r.stateHandler(c, state, err)
+	}
+}
+
+// stop - stops recovering, called when the consumer is destroyed.
+func (r *consumerRecovery) stop() {
+	r.quitOnce.Do(func() {
+		close(r.quit)
+	})
+}
+
+// AutoRecover - recreates the consumer when the station becomes unreachable, for example after a broker restart or
+// once the station was recreated, instead of stopping to consume. Attempts are retried with the backoff of the policy,
+// a policy with MaxAttempts 0 retries until the consumer is destroyed.
+func AutoRecover(policy RetryPolicy) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 || policy.MaxAttempts < 0 {
+			return errors.New("retry policy values can not be negative")
+		}
+		if policy.Multiplier < 1 {
+			return errors.New("retry policy multiplier has to be at least 1")
+		}
+		opts.AutoRecoverPolicy = &policy
+		return nil
+	}
+}
+
+// SubscriptionStateChanges - a handler called when an auto recovering consumer becomes recovering, active or failed.
+func SubscriptionStateChanges(handler SubscriptionStateHandler) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if handler == nil {
+			return errors.New("subscription state handler can not be nil")
+		}
+		opts.SubscriptionStateHandler = handler
+		return nil
+	}
+}
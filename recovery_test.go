@@ -0,0 +1,176 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func expectSubscriptionStates(t *testing.T, states <-chan SubscriptionState, expected ...SubscriptionState) {
+	t.Helper()
+	for _, state := range expected {
+		select {
+		case s := <-states:
+			if s != state {
+				t.Fatalf("expected the subscription to be %v, got %v", state, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the subscription to be %v", state)
+		}
+	}
+}
+
+func TestConsumerAutoRecover(t *testing.T) {
+	lost := &testJsConsumer{err: errors.New("consumer not found")}
+	recovered := &testJsConsumer{}
+	recovered.queue("a")
+	c := newTestConsumer(lost)
+
+	states := make(chan SubscriptionState, 10)
+	rebinds := 0
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1}
+	c.recovery = newConsumerRecovery(policy, func(_ *Consumer, state SubscriptionState, _ error) { states <- state }, func() error {
+		rebinds++
+		if rebinds == 1 {
+			return errors.New("station not found")
+		}
+		c.jsConsumersMu.Lock()
+		c.jsConsumers = map[int]jetstream.Consumer{1: recovered}
+		c.jsConsumersMu.Unlock()
+		return nil
+	})
+
+	received := make(chan string, 10)
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		for _, msg := range msgs {
+			received <- string(msg.Data())
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(context.Background())
+
+	expectSubscriptionStates(t, states, SubscriptionRecovering, SubscriptionActive)
+	if rebinds != 2 {
+		t.Errorf("expected the consumer to be recreated on the second attempt, got %v attempts", rebinds)
+	}
+	select {
+	case msg := <-received:
+		if msg != "a" {
+			t.Errorf("expected a, got %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the consume to go on after the recovery")
+	}
+	if !c.subscriptionActive.Load() || c.State() != ConsumerRunning {
+		t.Errorf("expected an active running consumer, got %v", c.State())
+	}
+}
+
+func TestConsumerAutoRecoverFailed(t *testing.T) {
+	c := newTestConsumer(&testJsConsumer{err: errors.New("stream not found")})
+	states := make(chan SubscriptionState, 10)
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, Multiplier: 2}
+	c.recovery = newConsumerRecovery(policy, func(_ *Consumer, state SubscriptionState, _ error) { states <- state }, func() error {
+		return errors.New("station not found")
+	})
+
+	if err := c.Consume(func([]*Msg, error, context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+	expectSubscriptionStates(t, states, SubscriptionRecovering, SubscriptionFailed)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != ConsumerIdle {
+		t.Errorf("expected a failed recovery to stop consuming, got %v", c.State())
+	}
+	if c.stationUnreachable(errors.New("stream not found")) {
+		t.Error("expected a failed recovery not to start again")
+	}
+}
+
+func TestStreamingAutoRecover(t *testing.T) {
+	lost := &testStreamingJsConsumer{}
+	recovered := &testStreamingJsConsumer{}
+	c := &Consumer{
+		stationName:   "test_station",
+		BatchSize:     10,
+		jsConsumers:   map[int]jetstream.Consumer{1: lost},
+		expiredPolicy: ExpiredDeliver,
+		consumeMode:   ConsumeStreaming,
+		errHandler:    func(*Consumer, error) {},
+	}
+	c.subscriptionActive.Store(true)
+	states := make(chan SubscriptionState, 10)
+	c.recovery = newConsumerRecovery(DefaultRetryPolicy(), func(_ *Consumer, state SubscriptionState, _ error) { states <- state }, func() error {
+		c.jsConsumersMu.Lock()
+		c.jsConsumers = map[int]jetstream.Consumer{1: recovered}
+		c.jsConsumersMu.Unlock()
+		return nil
+	})
+
+	if err := c.Consume(func([]*Msg, error, context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+	c.streamingErrHandler(nil, jetstream.ErrConsumerDeleted)
+	expectSubscriptionStates(t, states, SubscriptionRecovering, SubscriptionActive)
+	if !lost.cc.stopped || recovered.handler == nil {
+		t.Error("expected the stream to be restarted on the recovered consumer")
+	}
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !recovered.cc.stopped {
+		t.Error("expected the recovered stream to be stopped")
+	}
+}
+
+func TestAutoRecoverOpts(t *testing.T) {
+	opts := getDefaultConsumerOptions()
+	if err := AutoRecover(RetryPolicy{InitialBackoff: time.Second})(&opts); err == nil {
+		t.Error("expected a multiplier less than 1 to fail")
+	}
+	if err := AutoRecover(DefaultRetryPolicy())(&opts); err != nil || opts.AutoRecoverPolicy == nil {
+		t.Errorf("expected the recovery policy to be set, got %v", err)
+	}
+	if err := SubscriptionStateChanges(nil)(&opts); err == nil {
+		t.Error("expected a nil handler to fail")
+	}
+}
+
+func TestRecoveredPartitionsWhileProducing(t *testing.T) {
+	sn := "test_station"
+	conn := &Conn{
+		stationUpdatesSubs: map[string]*stationUpdateSub{sn: {}},
+		stationPartitions:  map[string]*PartitionsUpdate{sn: {PartitionsList: []int{1, 2}}},
+	}
+	p := &Producer{stationName: sn, conn: conn, PartitionGenerator: newRoundRobinGenerator([]int{1, 2})}
+	p.initProduceCache()
+	c := &Consumer{stationName: sn, conn: conn}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// a recovered consumer stores the partitions of the creation response
+		for i := 0; i < 100; i++ {
+			if err := c.handleCreationResp([]byte(`{"partitions_update":{"partitions_list":[1,2]}}`)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := p.partition(&ProduceOpts{ProducerPartitionKey: "key"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,273 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	replyStationHeader        = "$memphis_reply_station"
+	correlationIdHeader       = "$memphis_correlation_id"
+	replyStationPrefix        = "replies_"
+	replyConsumerName         = "reply_consumer"
+	replierProducerName       = "replier"
+	replyStationRetentionSec  = 600
+	replyPullInterval         = 10 * time.Millisecond
+	replyBatchMaxTimeToWait   = 100 * time.Millisecond
+	replyInboxCreationTimeout = 30 * time.Second
+)
+
+var ErrNotARequest = errors.New("message is not a request")
+
+// replyInbox - a private station of the connection, replies to its requests are produced into it and matched
+// to the waiting requests by their correlation id. Replies which arrive after their request is gone are dropped.
+type replyInbox struct {
+	mu       sync.Mutex
+	station  *Station
+	consumer *Consumer
+	pending  map[string]chan *Msg
+}
+
+func newReplyInbox() *replyInbox {
+	return &replyInbox{pending: make(map[string]chan *Msg)}
+}
+
+func (ri *replyInbox) register(correlationId string) chan *Msg {
+	ch := make(chan *Msg, 1)
+	ri.mu.Lock()
+	ri.pending[correlationId] = ch
+	ri.mu.Unlock()
+	return ch
+}
+
+func (ri *replyInbox) unregister(correlationId string) {
+	ri.mu.Lock()
+	delete(ri.pending, correlationId)
+	ri.mu.Unlock()
+}
+
+// deliver - passes a reply to its waiting request, returns false for late or unknown replies.
+func (ri *replyInbox) deliver(msg *Msg) bool {
+	correlationId := msg.natsHeaders().Get(correlationIdHeader)
+	ri.mu.Lock()
+	ch, ok := ri.pending[correlationId]
+	delete(ri.pending, correlationId)
+	ri.mu.Unlock()
+	if !ok {
+		return false
+	}
+	ch <- msg
+	return true
+}
+
+func (ri *replyInbox) handleReplies(msgs []*Msg, err error, ctx context.Context) {
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if err := msg.Ack(); err != nil {
+			log.Printf("Failed to ack reply: %v", memphisError(err))
+		}
+		ri.deliver(msg)
+	}
+}
+
+func (ri *replyInbox) close() {
+	if ri.consumer != nil {
+		ri.consumer.StopConsume()
+		_ = ri.consumer.Destroy()
+	}
+	if ri.station != nil {
+		_ = ri.station.Destroy()
+	}
+}
+
+// replyInboxCreation - the creation of the reply inbox, requests made meanwhile wait for it to be done.
+type replyInboxCreation struct {
+	done chan struct{}
+	ri   *replyInbox
+	err  error
+}
+
+// getReplyInbox - returns the reply inbox of the connection, creating it on the first request. The creation is done
+// without holding the lock, and waiting for it is bounded by the context and by the reply inbox creation timeout.
+// A failed creation is retried by the next request.
+func (c *Conn) getReplyInbox(ctx context.Context) (*replyInbox, error) {
+	c.repliesMu.Lock()
+	if c.replies != nil {
+		ri := c.replies
+		c.repliesMu.Unlock()
+		return ri, nil
+	}
+	creation := c.repliesCreation
+	if creation == nil {
+		creation = &replyInboxCreation{done: make(chan struct{})}
+		c.repliesCreation = creation
+		go c.createReplyInbox(creation)
+	}
+	c.repliesMu.Unlock()
+
+	ctx, cancelfunc := context.WithTimeout(ctx, replyInboxCreationTimeout)
+	defer cancelfunc()
+	select {
+	case <-creation.done:
+		return creation.ri, creation.err
+	case <-ctx.Done():
+		return nil, memphisError(ctx.Err())
+	}
+}
+
+func (c *Conn) createReplyInbox(creation *replyInboxCreation) {
+	creation.ri, creation.err = c.newReplyInboxStation()
+	c.repliesMu.Lock()
+	if creation.err == nil {
+		c.replies = creation.ri
+	}
+	c.repliesCreation = nil
+	c.repliesMu.Unlock()
+	close(creation.done)
+}
+
+// newReplyInboxStation - creates the private station of the reply inbox and starts consuming it.
+func (c *Conn) newReplyInboxStation() (*replyInbox, error) {
+	ri := newReplyInbox()
+	stationName := replyStationPrefix + c.ConnId
+	station, err := c.CreateStation(stationName, RetentionTypeOpt(MaxMessageAgeSeconds), RetentionVal(replyStationRetentionSec))
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	ri.station = station
+	consumer, err := c.CreateConsumer(stationName, replyConsumerName, PullInterval(replyPullInterval), BatchMaxWaitTime(replyBatchMaxTimeToWait))
+	if err != nil {
+		ri.close()
+		return nil, memphisError(err)
+	}
+	ri.consumer = consumer
+	if err := consumer.Consume(ri.handleReplies); err != nil {
+		ri.close()
+		return nil, memphisError(err)
+	}
+	return ri, nil
+}
+
+// replyProducers - the producers replies are produced with, one per requester station. They are destroyed when
+// the connection is closed.
+type replyProducers struct {
+	mu        sync.Mutex
+	producers map[string]*Producer
+}
+
+// get - returns the reply producer of a requester station, creating it on the first reply without holding the lock.
+func (rp *replyProducers) get(c *Conn, stationName string) (*Producer, error) {
+	rp.mu.Lock()
+	p := rp.producers[stationName]
+	rp.mu.Unlock()
+	if p != nil {
+		return p, nil
+	}
+
+	p, err := c.CreateProducer(stationName, replierProducerName)
+	if err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	// a concurrent reply may have created it already, both refer to the same producer of the station
+	if existing := rp.producers[stationName]; existing != nil {
+		return existing, nil
+	}
+	if rp.producers == nil {
+		rp.producers = make(map[string]*Producer)
+	}
+	rp.producers[stationName] = p
+	return p, nil
+}
+
+func (rp *replyProducers) destroy() {
+	rp.mu.Lock()
+	producers := rp.producers
+	rp.producers = nil
+	rp.mu.Unlock()
+	for _, p := range producers {
+		_ = p.Destroy()
+	}
+}
+
+// Producer.Request - produces a request message and waits for its reply until the context is done.
+// Replies are produced by the consumer of the request with Msg.Reply into a private station of the connection.
+func (p *Producer) Request(ctx context.Context, message any, opts ...ProduceOpt) (*Msg, error) {
+	if p.isMultiStationProducer {
+		return nil, memphisError(errors.New("requests are not supported by multi station producers"))
+	}
+	ri, err := p.conn.getReplyInbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	correlationId := id.String()
+
+	replies := ri.register(correlationId)
+	defer ri.unregister(correlationId)
+
+	opts = append(opts, func(o *ProduceOpts) error {
+		o.MsgHeaders.MsgHeaders[replyStationHeader] = []string{ri.station.Name}
+		o.MsgHeaders.MsgHeaders[correlationIdHeader] = []string{correlationId}
+		return nil
+	})
+	if err := p.produceToSingleStation(message, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, memphisError(ctx.Err())
+	}
+}
+
+// Msg.IsRequest - whether the message was produced by Producer.Request and expects a reply.
+func (m *Msg) IsRequest() bool {
+	headers := m.natsHeaders()
+	return headers.Get(replyStationHeader) != "" && headers.Get(correlationIdHeader) != ""
+}
+
+// Msg.Reply - produces a reply to a request message, returns ErrNotARequest for other messages.
+func (m *Msg) Reply(message any, opts ...ProduceOpt) error {
+	if !m.IsRequest() {
+		return ErrNotARequest
+	}
+	headers := m.natsHeaders()
+	correlationId := headers.Get(correlationIdHeader)
+	opts = append(opts, func(o *ProduceOpts) error {
+		o.MsgHeaders.MsgHeaders[correlationIdHeader] = []string{correlationId}
+		return nil
+	})
+	p, err := m.conn.replyProducers.get(m.conn, headers.Get(replyStationHeader))
+	if err != nil {
+		return memphisError(err)
+	}
+	return p.Produce(message, opts...)
+}
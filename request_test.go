@@ -0,0 +1,83 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestReplyInbox(t *testing.T) {
+	ri := newReplyInbox()
+	replies := ri.register("id_1")
+
+	reply := &Msg{msg: &nats.Msg{Header: nats.Header{correlationIdHeader: []string{"id_1"}}}}
+	if !ri.deliver(reply) {
+		t.Fatal("expected the reply to be delivered")
+	}
+	if got := <-replies; got != reply {
+		t.Error("unexpected reply")
+	}
+	if ri.deliver(reply) {
+		t.Error("a second reply to the same request should be dropped")
+	}
+
+	ri.register("id_2")
+	ri.unregister("id_2")
+	lateReply := &Msg{msg: &nats.Msg{Header: nats.Header{correlationIdHeader: []string{"id_2"}}}}
+	if ri.deliver(lateReply) {
+		t.Error("a reply after the request is gone should be dropped")
+	}
+}
+
+func TestReplyToNonRequest(t *testing.T) {
+	msg := &Msg{msg: &nats.Msg{Header: nats.Header{"key": []string{"value"}}}}
+	if msg.IsRequest() {
+		t.Error("message is not a request")
+	}
+	if err := msg.Reply([]byte("reply")); !errors.Is(err, ErrNotARequest) {
+		t.Errorf("expected ErrNotARequest, got %v", err)
+	}
+}
+
+func TestReplyInboxCreation(t *testing.T) {
+	c := &Conn{}
+	creation := &replyInboxCreation{done: make(chan struct{})}
+	c.repliesCreation = creation
+
+	// a request waiting for the creation gives up with its context, without blocking other requests
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.getReplyInbox(ctx); err == nil || err.Error() != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the request to give up, got %v", err)
+	}
+
+	ri := newReplyInbox()
+	waiting := make(chan *replyInbox)
+	go func() {
+		got, _ := c.getReplyInbox(context.Background())
+		waiting <- got
+	}()
+	c.repliesMu.Lock()
+	creation.ri = ri
+	c.replies = ri
+	c.repliesCreation = nil
+	c.repliesMu.Unlock()
+	close(creation.done)
+	if got := <-waiting; got != ri {
+		t.Error("expected the waiting request to get the created inbox")
+	}
+	if got, err := c.getReplyInbox(context.Background()); got != ri || err != nil {
+		t.Errorf("expected the created inbox, got %v", err)
+	}
+}
+
+func TestReplyProducers(t *testing.T) {
+	p := &Producer{Name: replierProducerName}
+	rp := replyProducers{producers: map[string]*Producer{"requester": p}}
+	if got, err := rp.get(nil, "requester"); got != p || err != nil {
+		t.Errorf("expected the reply producer to be reused, got %v", err)
+	}
+}
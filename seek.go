@@ -0,0 +1,226 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	ErrSeekNotConfirmed  = errors.New("seek has to be confirmed with the consumer group name")
+	ErrSeekWhileConsume  = errors.New("consume has to be stopped before seeking")
+	ErrSeekBadPartitions = errors.New("seek partition does not exist")
+	ErrSeekGroupActive   = errors.New("other consumers of the group are pulling messages, stop them or use ForceSeek")
+)
+
+type seekKind int
+
+const (
+	seekSequence seekKind = iota
+	seekTime
+	seekEarliest
+	seekLatest
+)
+
+// SeekPosition - where a consumer group continues consuming from after a seek.
+type SeekPosition struct {
+	kind     seekKind
+	sequence uint64
+	time     time.Time
+}
+
+// SeekToSequence - continue from a sequence, each partition has sequences of its own.
+func SeekToSequence(sequence uint64) SeekPosition {
+	return SeekPosition{kind: seekSequence, sequence: sequence}
+}
+
+// SeekToTime - continue from the first message stored at or after a time.
+func SeekToTime(t time.Time) SeekPosition {
+	return SeekPosition{kind: seekTime, time: t}
+}
+
+// SeekToEarliest - continue from the first message stored in the station.
+func SeekToEarliest() SeekPosition {
+	return SeekPosition{kind: seekEarliest}
+}
+
+// SeekToLatest - skip all the stored messages, only messages produced after the seek are consumed.
+func SeekToLatest() SeekPosition {
+	return SeekPosition{kind: seekLatest}
+}
+
+// SeekOpts - configuration options for a seek.
+type SeekOpts struct {
+	Confirm    string
+	Partitions []int
+	Force      bool
+}
+
+// SeekOpt - a function on the options for a seek.
+type SeekOpt func(*SeekOpts) error
+
+// ConfirmSeek - confirms the seek by naming the consumer group it resets, a seek without a matching confirmation fails.
+func ConfirmSeek(consumerGroup string) SeekOpt {
+	return func(opts *SeekOpts) error {
+		opts.Confirm = consumerGroup
+		return nil
+	}
+}
+
+// SeekPartitions - the partitions to seek, defaults to all the partitions of the station.
+func SeekPartitions(partitions ...int) SeekOpt {
+	return func(opts *SeekOpts) error {
+		opts.Partitions = partitions
+		return nil
+	}
+}
+
+// ForceSeek - seeks even while other consumers of the group are pulling messages.
+func ForceSeek() SeekOpt {
+	return func(opts *SeekOpts) error {
+		opts.Force = true
+		return nil
+	}
+}
+
+// Consumer.Seek - resets the position of the consumer group, affecting all the consumers of the group.
+// Messages not acked yet are redelivered according to the new position. The consumer has to stop consuming first,
+// and the seek has to be confirmed with ConfirmSeek.
+// The group is recreated on the broker, so the seek fails with ErrSeekGroupActive while other consumers of the group
+// are pulling messages. With ForceSeek it goes ahead, and group members without AutoRecover stop consuming.
+func (c *Consumer) Seek(ctx context.Context, position SeekPosition, opts ...SeekOpt) error {
+	seekOpts := SeekOpts{}
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt(&seekOpts); err != nil {
+				return memphisError(err)
+			}
+		}
+	}
+	if seekOpts.Confirm == "" || seekOpts.Confirm != c.ConsumerGroup {
+		return ErrSeekNotConfirmed
+	}
+	if c.State() != ConsumerIdle {
+		return ErrSeekWhileConsume
+	}
+
+	partitions := seekOpts.Partitions
+	if len(partitions) == 0 {
+		for partitionNumber := range c.partitionConsumers() {
+			partitions = append(partitions, partitionNumber)
+		}
+	}
+	for _, partitionNumber := range partitions {
+		if _, ok := c.partitionConsumers()[partitionNumber]; !ok {
+			return ErrSeekBadPartitions
+		}
+	}
+	// all the partitions are checked before any of them is recreated
+	infos := make(map[int]*jetstream.ConsumerInfo, len(partitions))
+	for _, partitionNumber := range partitions {
+		info, err := c.partitionConsumers()[partitionNumber].Info(ctx)
+		if err != nil {
+			return memphisError(err)
+		}
+		if info.NumWaiting > 0 && !seekOpts.Force {
+			return ErrSeekGroupActive
+		}
+		infos[partitionNumber] = info
+	}
+	for _, partitionNumber := range partitions {
+		if err := c.seekPartition(ctx, partitionNumber, infos[partitionNumber], position); err != nil {
+			return memphisError(err)
+		}
+	}
+	return nil
+}
+
+// seekPartition - recreates the consumer of a partition with the same configuration and a new deliver policy,
+// since updating a consumer can neither change its deliver policy nor reset its position. When the new consumer
+// can not be created, the consumer is recreated with its original configuration so the group is not left without it.
+func (c *Consumer) seekPartition(ctx context.Context, partitionNumber int, info *jetstream.ConsumerInfo, position SeekPosition) error {
+	origCfg := info.Config
+	cfg := info.Config
+	cfg.OptStartSeq = 0
+	cfg.OptStartTime = nil
+	switch position.kind {
+	case seekSequence:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = position.sequence
+	case seekTime:
+		startTime := position.time
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &startTime
+	case seekEarliest:
+		cfg.DeliverPolicy = jetstream.DeliverAllPolicy
+	case seekLatest:
+		cfg.DeliverPolicy = jetstream.DeliverNewPolicy
+	}
+
+	if err := c.conn.js.DeleteConsumer(ctx, info.Stream, info.Name); err != nil {
+		return err
+	}
+	jsCons, err := c.conn.js.CreateConsumer(ctx, info.Stream, cfg)
+	if err != nil {
+		// the seek context may be what failed the creation
+		restoreCtx, cancelfunc := context.WithTimeout(context.Background(), JetstreamOperationTimeout*time.Second)
+		defer cancelfunc()
+		restored, restoreErr := c.conn.js.CreateConsumer(restoreCtx, info.Stream, origCfg)
+		if restoreErr != nil {
+			return errors.Join(err, fmt.Errorf("restoring the consumer: %w", restoreErr))
+		}
+		c.setPartitionConsumer(partitionNumber, restored)
+		return err
+	}
+	c.setPartitionConsumer(partitionNumber, jsCons)
+	return nil
+}
+
+// startFromTime - moves the consumer group to a time, unless the group has already consumed from the partition
+// or other consumers of the group are pulling from it.
+func (c *Consumer) startFromTime(startTime time.Time) error {
+	ctx, cancelfunc := context.WithTimeout(context.Background(), JetstreamOperationTimeout*time.Second)
+	defer cancelfunc()
+	for partitionNumber, jsCons := range c.partitionConsumers() {
+		info, err := jsCons.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if info.Delivered.Consumer > 0 || info.NumWaiting > 0 {
+			continue
+		}
+		if err := c.seekPartition(ctx, partitionNumber, info, SeekToTime(startTime)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartConsumeFromTime - start consuming from the first message stored at or after a time,
+// applies only to consumer groups which have not consumed yet.
+func StartConsumeFromTime(startTime time.Time) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if startTime.IsZero() {
+			return errors.New("start time can not be zero")
+		}
+		opts.StartConsumeFromTime = startTime
+		return nil
+	}
+}
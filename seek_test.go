@@ -0,0 +1,169 @@
+package memphis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testInfoJsConsumer struct {
+	jetstream.Consumer
+	info *jetstream.ConsumerInfo
+}
+
+func (tc *testInfoJsConsumer) Info(context.Context) (*jetstream.ConsumerInfo, error) {
+	return tc.info, nil
+}
+
+type testSeekJetStream struct {
+	jetstream.JetStream
+	deleted    []string
+	created    []jetstream.ConsumerConfig
+	createErrs []error
+}
+
+func (js *testSeekJetStream) DeleteConsumer(ctx context.Context, stream string, consumer string) error {
+	js.deleted = append(js.deleted, stream+"/"+consumer)
+	return nil
+}
+
+func (js *testSeekJetStream) CreateConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	js.created = append(js.created, cfg)
+	if len(js.createErrs) > 0 {
+		err := js.createErrs[0]
+		js.createErrs = js.createErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &testInfoJsConsumer{info: &jetstream.ConsumerInfo{Stream: stream, Name: cfg.Durable, Config: cfg}}, nil
+}
+
+func newSeekTestConsumer(delivered uint64) (*Consumer, *testSeekJetStream) {
+	js := &testSeekJetStream{}
+	jsConsumers := map[int]jetstream.Consumer{}
+	for _, p := range []int{1, 2} {
+		jsConsumers[p] = &testInfoJsConsumer{info: &jetstream.ConsumerInfo{
+			Stream:    "station$" + strconv.Itoa(p),
+			Name:      "cg",
+			Config:    jetstream.ConsumerConfig{Durable: "cg", MaxDeliver: 2, DeliverPolicy: jetstream.DeliverAllPolicy},
+			Delivered: jetstream.SequenceInfo{Consumer: delivered * uint64(p-1)},
+		}}
+	}
+	return &Consumer{ConsumerGroup: "cg", conn: &Conn{js: js}, jsConsumers: jsConsumers}, js
+}
+
+func TestConsumerSeek(t *testing.T) {
+	c, js := newSeekTestConsumer(0)
+	ctx := context.Background()
+
+	if err := c.Seek(ctx, SeekToEarliest()); err != ErrSeekNotConfirmed {
+		t.Errorf("expected a seek without confirmation to fail, got %v", err)
+	}
+	if err := c.Seek(ctx, SeekToEarliest(), ConfirmSeek("other_cg")); err != ErrSeekNotConfirmed {
+		t.Errorf("expected a seek confirmed with another group to fail, got %v", err)
+	}
+	c.state = ConsumerRunning
+	if err := c.Seek(ctx, SeekToEarliest(), ConfirmSeek("cg")); err != ErrSeekWhileConsume {
+		t.Errorf("expected a seek while consuming to fail, got %v", err)
+	}
+	c.state = ConsumerIdle
+	if err := c.Seek(ctx, SeekToEarliest(), ConfirmSeek("cg"), SeekPartitions(3)); err != ErrSeekBadPartitions {
+		t.Errorf("expected a seek of a missing partition to fail, got %v", err)
+	}
+	if len(js.deleted) != 0 {
+		t.Fatal("expected failed seeks to leave the consumers")
+	}
+
+	if err := c.Seek(ctx, SeekToSequence(42), ConfirmSeek("cg"), SeekPartitions(2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.deleted) != 1 || js.deleted[0] != "station$2/cg" {
+		t.Errorf("expected the consumer of partition 2 to be recreated, got %v", js.deleted)
+	}
+	cfg := js.created[0]
+	if cfg.DeliverPolicy != jetstream.DeliverByStartSequencePolicy || cfg.OptStartSeq != 42 || cfg.MaxDeliver != 2 || cfg.Durable != "cg" {
+		t.Errorf("unexpected consumer config %+v", cfg)
+	}
+
+	startTime := time.Now().Add(-time.Hour)
+	if err := c.Seek(ctx, SeekToTime(startTime), ConfirmSeek("cg")); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.created) != 3 {
+		t.Fatalf("expected all the partitions to be recreated, got %v", len(js.created))
+	}
+	for _, cfg := range js.created[1:] {
+		if cfg.DeliverPolicy != jetstream.DeliverByStartTimePolicy || !cfg.OptStartTime.Equal(startTime) || cfg.OptStartSeq != 0 {
+			t.Errorf("unexpected consumer config %+v", cfg)
+		}
+	}
+}
+
+func TestStartConsumeFromTime(t *testing.T) {
+	c, js := newSeekTestConsumer(5)
+	if err := c.startFromTime(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.deleted) != 1 || js.deleted[0] != "station$1/cg" {
+		t.Errorf("expected only the partition which was not consumed yet to move, got %v", js.deleted)
+	}
+	if err := StartConsumeFromTime(time.Time{})(&ConsumerOpts{}); err == nil {
+		t.Error("expected error for a zero start time")
+	}
+}
+
+func TestConsumerSeekRestore(t *testing.T) {
+	c, js := newSeekTestConsumer(0)
+	ctx := context.Background()
+	createErr := errors.New("create failed")
+	js.createErrs = []error{createErr}
+	if err := c.Seek(ctx, SeekToSequence(42), ConfirmSeek("cg"), SeekPartitions(1)); err == nil || err.Error() != createErr.Error() {
+		t.Errorf("expected the create error, got %v", err)
+	}
+	if len(js.created) != 2 || js.created[1].DeliverPolicy != jetstream.DeliverAllPolicy {
+		t.Fatalf("expected the consumer to be restored with its original configuration, got %v", js.created)
+	}
+	info, _ := c.jsConsumers[1].Info(ctx)
+	if info.Config.DeliverPolicy != jetstream.DeliverAllPolicy {
+		t.Error("expected the restored consumer to be used")
+	}
+
+	restoreErr := errors.New("restore failed")
+	js.createErrs = []error{createErr, restoreErr}
+	err := c.Seek(ctx, SeekToSequence(42), ConfirmSeek("cg"), SeekPartitions(1))
+	if err == nil || !strings.Contains(err.Error(), createErr.Error()) || !strings.Contains(err.Error(), restoreErr.Error()) {
+		t.Errorf("expected both the create and the restore errors, got %v", err)
+	}
+}
+
+func TestConsumerSeekGroupActive(t *testing.T) {
+	c, js := newSeekTestConsumer(0)
+	ctx := context.Background()
+	c.jsConsumers[2].(*testInfoJsConsumer).info.NumWaiting = 1
+
+	if err := c.Seek(ctx, SeekToEarliest(), ConfirmSeek("cg")); err != ErrSeekGroupActive {
+		t.Errorf("expected a seek while the group is pulling to fail, got %v", err)
+	}
+	if len(js.deleted) != 0 {
+		t.Fatalf("expected no partition to be recreated, got %v", js.deleted)
+	}
+	if err := c.startFromTime(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.deleted) != 1 || js.deleted[0] != "station$1/cg" {
+		t.Errorf("expected only the partition nobody pulls from to move, got %v", js.deleted)
+	}
+
+	if err := c.Seek(ctx, SeekToEarliest(), ConfirmSeek("cg"), ForceSeek()); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.deleted) != 3 {
+		t.Errorf("expected a forced seek to recreate all the partitions, got %v", js.deleted)
+	}
+}
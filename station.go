@@ -15,17 +15,22 @@
 package memphis
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/pkg/crc64"
 	"github.com/nats-io/nats.go"
 
 	graphqlParse "github.com/graph-gophers/graphql-go"
@@ -349,6 +354,8 @@ type stationUpdateSub struct {
 	schemaUpdateCh  chan SchemaUpdate
 	schemaUpdateSub *nats.Subscription
 	schemaDetails   schemaDetails
+	// activeSchema - a snapshot of schemaDetails, producers read it without locking
+	activeSchema atomic.Pointer[schemaDetails]
 }
 
 type stationFunctionSub struct {
@@ -357,6 +364,9 @@ type stationFunctionSub struct {
 	FunctionsUpdateSub *nats.Subscription
 	StationFunctionsMu sync.RWMutex
 	FunctionsDetails   functionsDetails
+	stationName        string
+	// subjects - the subject of every partition with a function, producers read it without locking
+	subjects atomic.Pointer[map[int]string]
 }
 
 type FunctionsUpdate struct {
@@ -421,13 +431,7 @@ func (c *Conn) listenToFunctionsUpdates(stationName string, initialFunctionsMap
 	defer stationFunctionsSubsLock.Unlock()
 	sfs, ok := c.stationFunctionSubs[sn]
 	if !ok {
-		c.stationFunctionSubs[sn] = &stationFunctionSub{
-			RefCount:          1,
-			FunctionsUpdateCh: make(chan FunctionsUpdate),
-			FunctionsDetails: functionsDetails{
-				PartitionsFunctions: initialFunctionsMap,
-			},
-		}
+		c.stationFunctionSubs[sn] = newStationFunctionSub(sn, initialFunctionsMap)
 		sfs := c.stationFunctionSubs[sn]
 		functionsUpdatesSubject := fmt.Sprintf(functionsUpdatesSubjectTemplate, sn)
 		go sfs.functionsUpdatesHandler()
@@ -543,6 +547,7 @@ func (sus *stationUpdateSub) schemaUpdatesHandler(lock *sync.RWMutex) {
 		case SchemaUpdateTypeDrop:
 			sd.handleSchemaUpdateDrop()
 		}
+		sus.storeSchema()
 		lock.Unlock()
 	}
 }
@@ -555,11 +560,54 @@ func (sfs *stationFunctionSub) functionsUpdatesHandler() {
 		}
 
 		sfs.StationFunctionsMu.Lock()
-		sfs.FunctionsDetails.PartitionsFunctions = update.Functions
+		sfs.setFunctions(update.Functions)
 		sfs.StationFunctionsMu.Unlock()
 	}
 }
 
+// storeSchema - publishes the current schema details to producers, called after every change with the lock held.
+func (sus *stationUpdateSub) storeSchema() {
+	sd := sus.schemaDetails
+	sus.activeSchema.Store(&sd)
+}
+
+// schema - the latest schema details, read without locking.
+func (sus *stationUpdateSub) schema() *schemaDetails {
+	if sd := sus.activeSchema.Load(); sd != nil {
+		return sd
+	}
+	return &schemaDetails{}
+}
+
+func newStationFunctionSub(internalStationName string, functions map[int]int) *stationFunctionSub {
+	sfs := &stationFunctionSub{
+		RefCount:          1,
+		FunctionsUpdateCh: make(chan FunctionsUpdate),
+		stationName:       internalStationName,
+	}
+	sfs.setFunctions(functions)
+	return sfs
+}
+
+func (sfs *stationFunctionSub) setFunctions(functions map[int]int) {
+	sfs.FunctionsDetails.PartitionsFunctions = functions
+	subjects := make(map[int]string, len(functions))
+	for partition, funcID := range functions {
+		subjects[partition] = sfs.stationName + "$" + strconv.Itoa(partition) + ".functions." + strconv.Itoa(funcID)
+	}
+	sfs.subjects.Store(&subjects)
+}
+
+// subject - the subject of messages to a partition which has a function.
+func (sfs *stationFunctionSub) subject(partition int) (string, bool) {
+	subjects := sfs.subjects.Load()
+	if subjects == nil {
+		return "", false
+	}
+	subject, ok := (*subjects)[partition]
+	return subject, ok
+}
+
 func (sd *schemaDetails) handleSchemaUpdateInit(sui SchemaUpdateInit) {
 	sd.name = sui.SchemaName
 	sd.schemaType = sui.SchemaType
@@ -835,3 +883,77 @@ func (sd *schemaDetails) validAvroSchemaMsg(msg any) ([]byte, error) {
 
 	return msgBytes, nil
 }
+
+// avroSingleObjectMagic - the marker which prefixes messages in the avro single object encoding.
+var avroSingleObjectMagic = []byte{0xC3, 0x01}
+
+func (sd *schemaDetails) avroSingleObjectHeader() []byte {
+	h := crc64.New()
+	_, _ = h.Write([]byte(sd.avroSchema.String()))
+	header := make([]byte, len(avroSingleObjectMagic)+8)
+	copy(header, avroSingleObjectMagic)
+	binary.LittleEndian.PutUint64(header[len(avroSingleObjectMagic):], h.Sum64())
+	return header
+}
+
+// avroBinaryBody - strips and verifies the single object encoding header if present and validates the avro binary body.
+func (sd *schemaDetails) avroBinaryBody(msgBytes []byte) ([]byte, error) {
+	body := msgBytes
+	if bytes.HasPrefix(msgBytes, avroSingleObjectMagic) {
+		header := sd.avroSingleObjectHeader()
+		if len(msgBytes) < len(header) {
+			return nil, memphisError(errors.New("Bad Avro format - single object header is too short"))
+		}
+		if !bytes.Equal(msgBytes[:len(header)], header) {
+			return nil, memphisError(errors.New("Bad Avro format - schema fingerprint does not match the station schema"))
+		}
+		body = msgBytes[len(header):]
+	}
+	var message interface{}
+	if err := avro.Unmarshal(sd.avroSchema, body, &message); err != nil {
+		return nil, memphisError(errors.New("Bad Avro format - " + err.Error()))
+	}
+	return body, nil
+}
+
+// avroBinaryMsg - validates the message and encodes it into avro binary, optionally with a single object encoding header.
+func (sd *schemaDetails) avroBinaryMsg(msg any, singleObject bool) ([]byte, error) {
+	var (
+		msgBytes []byte
+		err      error
+		message  interface{}
+	)
+
+	switch msg.(type) {
+	case []byte:
+		if err := json.Unmarshal(msg.([]byte), &message); err != nil {
+			// the message may already be avro binary encoded
+			msgBytes, err = sd.avroBinaryBody(msg.([]byte))
+			if err != nil {
+				return nil, memphisError(err)
+			}
+			break
+		}
+		message = avroNativeValue(sd.avroSchema, message)
+	case map[string]interface{}:
+		message = avroNativeValue(sd.avroSchema, msg)
+	default:
+		msgType := reflect.TypeOf(msg).Kind()
+		if msgType != reflect.Struct {
+			return nil, memphisError(errors.New("unsupported message type"))
+		}
+		message = msg
+	}
+
+	if msgBytes == nil {
+		msgBytes, err = avro.Marshal(sd.avroSchema, message)
+		if err != nil {
+			return nil, memphisError(err)
+		}
+	}
+
+	if singleObject {
+		msgBytes = append(sd.avroSingleObjectHeader(), msgBytes...)
+	}
+	return msgBytes, nil
+}
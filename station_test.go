@@ -16,6 +16,8 @@ package memphis
 import (
 	"testing"
 	"time"
+
+	"github.com/hamba/avro/v2"
 )
 
 func TestCreateStation(t *testing.T) {
@@ -76,3 +78,41 @@ func TestCreateStationWithDefaults(t *testing.T) {
 	}
 	s.Destroy()
 }
+
+func TestAvroBinaryMsg(t *testing.T) {
+	sd := schemaDetails{schemaType: "avro", activeVersion: SchemaVersion{Content: codecTestAvroSchema}}
+	if err := sd.compileAvroSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, singleObject := range []bool{false, true} {
+		msgBytes, err := sd.avroBinaryMsg([]byte(`{"name": "memphis", "age": 3}`), singleObject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := sd.avroBinaryBody(msgBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v codecTestMsg
+		if err := avro.Unmarshal(sd.avroSchema, body, &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Name != "memphis" || v.Age != 3 {
+			t.Errorf("unexpected decoded value %+v", v)
+		}
+	}
+
+	if _, err := sd.avroBinaryMsg(map[string]interface{}{"name": 3}, false); err == nil {
+		t.Error("expected validation error for a message which does not match the schema")
+	}
+
+	msgBytes, err := sd.avroBinaryMsg(codecTestMsg{Name: "memphis", Age: 3}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgBytes[2] ^= 0xFF
+	if _, err := sd.avroBinaryBody(msgBytes); err == nil {
+		t.Error("expected fingerprint mismatch error")
+	}
+}
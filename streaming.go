@@ -0,0 +1,226 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ConsumeMode - how a consumer gets messages from the broker when Consume is called.
+type ConsumeMode int
+
+const (
+	// ConsumePolling - fetch a batch every pull interval, the default.
+	ConsumePolling ConsumeMode = iota
+	// ConsumeStreaming - keep pull requests open so messages are pushed to the handler as soon as they arrive,
+	// the connection health is checked with idle heartbeats.
+	ConsumeStreaming
+)
+
+// consumeStreaming - starts streaming from the given partition, or from all the partitions in case none is given.
+// Each message is passed to the handler on its own, handler calls are never concurrent.
+func (c *Consumer) consumeStreaming(run *consumeRun, handlerFunc ConsumeHandler, partitionKey string, partitionNum int) error {
+	partitionNumber := 0
+	if partitionKey != "" || partitionNum > 0 {
+		var err error
+		partitionNumber, err = c.fetchPartition(partitionKey, partitionNum)
+		if err != nil {
+			return err
+		}
+	}
+
+	// dead-letter messages are passed to the handler under the same lock as streamed ones
+	c.setDlsHandler(run, func(msgs []*Msg, err error, ctx context.Context) {
+		c.streamHandlerMu.Lock()
+		defer c.streamHandlerMu.Unlock()
+		handlerFunc(msgs, err, ctx)
+	})
+	run.streams = &consumeStreams{start: func() ([]jetstream.ConsumeContext, error) {
+		return c.startStreams(run, handlerFunc, partitionNumber)
+	}}
+	if err := run.streams.restart(); err != nil {
+		return err
+	}
+
+	go func() {
+		<-run.ctx.Done()
+		run.streams.stop()
+		// wait for the current handler call
+		c.streamHandlerMu.Lock()
+		c.streamHandlerMu.Unlock()
+		c.finishConsume(run)
+	}()
+	return nil
+}
+
+// startStreams - starts a consume context for the given partition, or for each partition when it is 0.
+func (c *Consumer) startStreams(run *consumeRun, handlerFunc ConsumeHandler, partitionNumber int) ([]jetstream.ConsumeContext, error) {
+	jsConsumers := c.partitionConsumers()
+	partitions := make([]int, 0, len(jsConsumers))
+	if partitionNumber > 0 {
+		partitions = append(partitions, partitionNumber)
+	} else {
+		for partitionNumber := range jsConsumers {
+			partitions = append(partitions, partitionNumber)
+		}
+	}
+
+	internalStationName := getInternalName(c.stationName)
+	consumeContexts := make([]jetstream.ConsumeContext, 0, len(partitions))
+	stopAll := func() {
+		for _, cc := range consumeContexts {
+			cc.Stop()
+		}
+	}
+	for _, partitionNumber := range partitions {
+		partitionNumber := partitionNumber
+		jsCons, ok := jsConsumers[partitionNumber]
+		if !ok {
+			stopAll()
+			return nil, fmt.Errorf("partition %d does not exist", partitionNumber)
+		}
+		cc, err := jsCons.Consume(func(msg jetstream.Msg) {
+			if run.ctx.Err() != nil {
+				// the consume was stopped, the message is redelivered after the max ack time
+				return
+			}
+			c.subscriptionActive.Store(true)
+			wrappedMsgs := []*Msg{{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, internalStationName: internalStationName, partition: partitionNumber}}
+			wrappedMsgs = c.deferNotDueMsgs(c.handleExpiredMsgs(wrappedMsgs))
+			if len(wrappedMsgs) == 0 {
+				return
+			}
+			c.streamHandlerMu.Lock()
+			defer c.streamHandlerMu.Unlock()
+			handlerFunc(wrappedMsgs, nil, run.handlerCtx)
+		}, c.streamingOpts()...)
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+		consumeContexts = append(consumeContexts, cc)
+	}
+	return consumeContexts, nil
+}
+
+// consumeStreams - the consume contexts of a streaming run, they are restarted once a lost consumer was recovered.
+type consumeStreams struct {
+	mu       sync.Mutex
+	start    func() ([]jetstream.ConsumeContext, error)
+	contexts []jetstream.ConsumeContext
+	stopped  bool
+}
+
+// restart - stops the current consume contexts and starts new ones, does nothing once the streams were stopped.
+func (s *consumeStreams) restart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	for _, cc := range s.contexts {
+		cc.Stop()
+	}
+	contexts, err := s.start()
+	s.contexts = contexts
+	return err
+}
+
+func (s *consumeStreams) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for _, cc := range s.contexts {
+		cc.Stop()
+	}
+	s.contexts = nil
+}
+
+func (c *Consumer) streamingOpts() []jetstream.PullConsumeOpt {
+	opts := []jetstream.PullConsumeOpt{jetstream.ConsumeErrHandler(c.streamingErrHandler)}
+	if c.maxOutstandingBytes > 0 {
+		opts = append(opts, jetstream.PullMaxBytes(c.maxOutstandingBytes))
+	} else if c.maxOutstandingMsgs > 0 {
+		opts = append(opts, jetstream.PullMaxMessages(c.maxOutstandingMsgs))
+	}
+	if c.idleHeartbeat > 0 {
+		// pull requests have to live at least twice the heartbeat interval
+		expiry := jetstream.DefaultExpires
+		if 3*c.idleHeartbeat > expiry {
+			expiry = 3 * c.idleHeartbeat
+		}
+		opts = append(opts, jetstream.PullHeartbeat(c.idleHeartbeat), jetstream.PullExpiry(expiry))
+	}
+	return opts
+}
+
+// streamingErrHandler - the health check of a streaming consumer, missed heartbeats and a deleted consumer make the
+// station unreachable until messages arrive again or the consumer is recovered.
+func (c *Consumer) streamingErrHandler(_ jetstream.ConsumeContext, err error) {
+	if errors.Is(err, jetstream.ErrNoHeartbeat) || errors.Is(err, jetstream.ErrConsumerDeleted) || errors.Is(err, jetstream.ErrConsumerNotFound) {
+		c.stationUnreachable(err)
+		return
+	}
+	c.callErrHandler(err)
+}
+
+// ConsumerConsumeMode - how the consumer gets messages when Consume is called, defaults to memphis.ConsumePolling.
+func ConsumerConsumeMode(mode ConsumeMode) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.ConsumeMode = mode
+		return nil
+	}
+}
+
+// MaxOutstandingMsgs - max number of messages a streaming consumer holds which were not passed to the handler yet.
+func MaxOutstandingMsgs(maxMsgs int) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if maxMsgs < 1 {
+			return errors.New("max outstanding messages has to be a positive number")
+		}
+		opts.MaxOutstandingMsgs = maxMsgs
+		return nil
+	}
+}
+
+// MaxOutstandingBytes - max size in bytes of the messages a streaming consumer holds which were not passed to the handler yet,
+// can not be used together with MaxOutstandingMsgs.
+func MaxOutstandingBytes(maxBytes int) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if maxBytes < 1 {
+			return errors.New("max outstanding bytes has to be a positive number")
+		}
+		opts.MaxOutstandingBytes = maxBytes
+		return nil
+	}
+}
+
+// IdleHeartbeat - interval of the heartbeats a streaming consumer expects while no messages arrive, missing two in a row
+// makes the station unreachable. Defaults to half of the pull request expiry.
+func IdleHeartbeat(interval time.Duration) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if interval <= 0 {
+			return errors.New("idle heartbeat has to be a positive duration")
+		}
+		opts.IdleHeartbeat = interval
+		return nil
+	}
+}
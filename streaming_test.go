@@ -0,0 +1,132 @@
+package memphis
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type testConsumeContext struct {
+	stopped bool
+}
+
+func (cc *testConsumeContext) Stop() { cc.stopped = true }
+
+type testStreamingJsConsumer struct {
+	jetstream.Consumer
+	handler jetstream.MessageHandler
+	opts    []jetstream.PullConsumeOpt
+	cc      *testConsumeContext
+}
+
+func (tc *testStreamingJsConsumer) Consume(handler jetstream.MessageHandler, opts ...jetstream.PullConsumeOpt) (jetstream.ConsumeContext, error) {
+	tc.handler = handler
+	tc.opts = opts
+	tc.cc = &testConsumeContext{}
+	return tc.cc, nil
+}
+
+func TestConsumeStreaming(t *testing.T) {
+	partitions := map[int]*testStreamingJsConsumer{1: {}, 2: {}}
+	var errs []error
+	c := &Consumer{
+		stationName:        "test_station",
+		BatchSize:          10,
+		jsConsumers:        map[int]jetstream.Consumer{1: partitions[1], 2: partitions[2]},
+		PartitionGenerator: newRoundRobinGenerator([]int{1, 2}),
+		expiredPolicy:      ExpiredDeliver,
+		consumeMode:        ConsumeStreaming,
+		idleHeartbeat:      20 * time.Second,
+		maxOutstandingMsgs: 100,
+		errHandler:         func(_ *Consumer, err error) { errs = append(errs, err) },
+	}
+	c.subscriptionActive.Store(true)
+
+	var mu sync.Mutex
+	var received []string
+	unlockedCalls := 0
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c.streamHandlerMu.TryLock() {
+			c.streamHandlerMu.Unlock()
+			unlockedCalls++
+		}
+		for _, msg := range msgs {
+			received = append(received, string(msg.Data()))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range partitions {
+		if p.handler == nil {
+			t.Fatal("expected all the partitions to be streamed")
+		}
+		// error handler, max messages, heartbeat and expiry
+		if len(p.opts) != 4 {
+			t.Errorf("expected 4 pull options, got %v", len(p.opts))
+		}
+	}
+	partitions[1].handler(&testJsMsg{data: []byte("a")})
+	partitions[2].handler(&testJsMsg{data: []byte("b")})
+	if len(received) != 2 {
+		t.Errorf("expected 2 messages, got %v", received)
+	}
+	c.run.dlsHandler([]*Msg{{msg: &nats.Msg{Data: []byte("dls")}}}, nil, context.Background())
+	if len(received) != 3 || unlockedCalls != 0 {
+		t.Errorf("expected the handler calls to be serialized with dead-letter messages, got %v calls without the lock", unlockedCalls)
+	}
+	if !c.streamRunning() {
+		t.Error("expected the ping to be skipped while streaming")
+	}
+
+	c.streamingErrHandler(nil, jetstream.ErrNoHeartbeat)
+	if c.subscriptionActive.Load() || len(errs) != 1 || errs[0] != ConsumerErrStationUnreachable {
+		t.Errorf("expected a missed heartbeat to make the station unreachable, got %v", errs)
+	}
+	partitions[1].handler(&testJsMsg{data: []byte("c")})
+	if !c.subscriptionActive.Load() {
+		t.Error("expected a message to make the station reachable again")
+	}
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range partitions {
+		if !p.cc.stopped {
+			t.Error("expected the pull requests to be stopped")
+		}
+	}
+	if c.State() != ConsumerIdle {
+		t.Errorf("expected the consumer to be idle, got %v", c.State())
+	}
+	if c.streamRunning() {
+		t.Error("expected the ping to check the consumer once the stream is stopped")
+	}
+}
+
+func TestStreamingConsumerOpts(t *testing.T) {
+	opts := getDefaultConsumerOptions()
+	if err := MaxOutstandingMsgs(0)(&opts); err == nil {
+		t.Error("expected error for zero max outstanding messages")
+	}
+	if err := MaxOutstandingBytes(-1)(&opts); err == nil {
+		t.Error("expected error for negative max outstanding bytes")
+	}
+	if err := IdleHeartbeat(0)(&opts); err == nil {
+		t.Error("expected error for zero idle heartbeat")
+	}
+	for _, opt := range []ConsumerOpt{ConsumerConsumeMode(ConsumeStreaming), MaxOutstandingBytes(1024), IdleHeartbeat(time.Second)} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opts.ConsumeMode != ConsumeStreaming || opts.MaxOutstandingBytes != 1024 || opts.IdleHeartbeat != time.Second {
+		t.Errorf("unexpected options %+v", opts)
+	}
+}
@@ -0,0 +1,179 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hamba/avro/v2"
+)
+
+var ConsumerErrDecodeFailed = errors.New("message decoding has failed")
+
+// TypedProducer - a producer of messages of type T, encoded with a codec.
+type TypedProducer[T any] struct {
+	producer *Producer
+	codec    Codec[T]
+}
+
+// NewTypedProducer - creates a producer which encodes messages of type T with the given codec.
+func NewTypedProducer[T any](c *Conn, stationName interface{}, name string, codec Codec[T], opts ...ProducerOpt) (*TypedProducer[T], error) {
+	if codec == nil {
+		return nil, memphisError(errors.New("codec can not be nil"))
+	}
+	p, err := c.CreateProducer(stationName, name, opts...)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return &TypedProducer[T]{producer: p, codec: codec}, nil
+}
+
+// TypedProducer.Produce - encodes and produces a message into the station.
+func (tp *TypedProducer[T]) Produce(message T, opts ...ProduceOpt) error {
+	data, err := tp.codec.Encode(message)
+	if err != nil {
+		return memphisError(err)
+	}
+	return tp.producer.Produce(data, opts...)
+}
+
+// TypedProducer.Producer - get the underlying producer.
+func (tp *TypedProducer[T]) Producer() *Producer {
+	return tp.producer
+}
+
+// TypedProducer.Destroy - destroy this producer.
+func (tp *TypedProducer[T]) Destroy(options ...RequestOpt) error {
+	return tp.producer.Destroy(options...)
+}
+
+// TypedMsg - a received message together with its decoded value.
+type TypedMsg[T any] struct {
+	*Msg
+	Value T
+}
+
+// TypedConsumeHandler - handler for consumed typed messages
+type TypedConsumeHandler[T any] func([]*TypedMsg[T], error, context.Context)
+
+// TypedConsumer - a consumer of messages of type T, decoded with a codec.
+// Messages which fail schema validation or decoding never reach the handler, they are passed to the
+// consumer error handler, or sent to the dead-letter station when DeadLetterOnDecodeError is set.
+type TypedConsumer[T any] struct {
+	consumer *Consumer
+	codec    Codec[T]
+}
+
+// NewTypedConsumer - creates a consumer which decodes messages of type T with the given codec.
+func NewTypedConsumer[T any](c *Conn, stationName, consumerName string, codec Codec[T], opts ...ConsumerOpt) (*TypedConsumer[T], error) {
+	if codec == nil {
+		return nil, memphisError(errors.New("codec can not be nil"))
+	}
+	consumer, err := c.CreateConsumer(stationName, consumerName, opts...)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	return &TypedConsumer[T]{consumer: consumer, codec: codec}, nil
+}
+
+// TypedConsumer.Consume - start consuming messages, the handler receives the decoded messages.
+func (tc *TypedConsumer[T]) Consume(handlerFunc TypedConsumeHandler[T], opts ...ConsumingOpt) error {
+	return tc.consumer.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		handlerFunc(tc.decodeMsgs(msgs), err, ctx)
+	}, opts...)
+}
+
+// TypedConsumer.Fetch - immediately fetch a batch of decoded messages.
+func (tc *TypedConsumer[T]) Fetch(batchSize int, prefetch bool, opts ...ConsumingOpt) ([]*TypedMsg[T], error) {
+	msgs, err := tc.consumer.Fetch(batchSize, prefetch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return tc.decodeMsgs(msgs), nil
+}
+
+// TypedConsumer.Consumer - get the underlying consumer.
+func (tc *TypedConsumer[T]) Consumer() *Consumer {
+	return tc.consumer
+}
+
+// TypedConsumer.StopConsume - stops the continuous consume operation.
+func (tc *TypedConsumer[T]) StopConsume() {
+	tc.consumer.StopConsume()
+}
+
+// TypedConsumer.Stop - stops consuming and waits for the current handler call to return, or for the context to be done.
+func (tc *TypedConsumer[T]) Stop(ctx context.Context) error {
+	return tc.consumer.Stop(ctx)
+}
+
+// TypedConsumer.Destroy - destroy this consumer.
+func (tc *TypedConsumer[T]) Destroy(options ...RequestOpt) error {
+	return tc.consumer.Destroy(options...)
+}
+
+func (tc *TypedConsumer[T]) decodeMsgs(msgs []*Msg) []*TypedMsg[T] {
+	typedMsgs := make([]*TypedMsg[T], 0, len(msgs))
+	for _, msg := range msgs {
+		v, err := tc.decodeMsg(msg)
+		if err != nil {
+			tc.consumer.handleDecodeErr(msg, err)
+			continue
+		}
+		typedMsgs = append(typedMsgs, &TypedMsg[T]{Msg: msg, Value: v})
+	}
+	return typedMsgs
+}
+
+func (tc *TypedConsumer[T]) decodeMsg(msg *Msg) (T, error) {
+	var v T
+	data := msg.Data()
+	sd, err := tc.consumer.conn.getSchemaDetails(tc.consumer.stationName)
+	if err != nil {
+		return v, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+	}
+	if sd.schemaType == "avro" && msg.isAvroBinary() {
+		// codecs decode the json wire format of avro stations
+		body, err := sd.avroBinaryBody(data)
+		if err != nil {
+			return v, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+		}
+		var message interface{}
+		if err := avro.Unmarshal(sd.avroSchema, body, &message); err != nil {
+			return v, memphisError(err)
+		}
+		if data, err = json.Marshal(message); err != nil {
+			return v, memphisError(err)
+		}
+	} else if sd.schemaType != "" {
+		// empty schema type means there is no schema and validation is not needed
+		if _, err := sd.validateMsg(data); err != nil {
+			return v, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+		}
+	}
+	return tc.codec.Decode(data)
+}
+
+func (c *Consumer) handleDecodeErr(msg *Msg, err error) {
+	if c.deadLetterOnDecodeErr {
+		if dlsErr := msg.DeadLetter(err.Error()); dlsErr == nil {
+			return
+		}
+	}
+	c.callErrHandler(fmt.Errorf("%w: %v", ConsumerErrDecodeFailed, err))
+}
@@ -0,0 +1,297 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hamba/avro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// errSentToDls - the message has failed schema validation and was sent to the dead-letter station instead of the station.
+var errSentToDls = errors.New("message was sent to the dead-letter station")
+
+// ValidationFailurePolicy - what a producer does with messages which fail schema validation.
+type ValidationFailurePolicy int
+
+const (
+	// ValidationReject - produce fails, the message is sent to the dead-letter station if the station is configured to, the default.
+	ValidationReject ValidationFailurePolicy = iota
+	// ValidationDeadLetter - the message is sent to the dead-letter station and produce does not fail.
+	ValidationDeadLetter
+	// ValidationCoerce - the message is fixed to match the schema and produced, messages which can not be fixed are rejected.
+	// Protobuf and avro messages get the schema defaults of missing fields and lose unknown fields,
+	// json messages lose the properties which the schema does not allow.
+	ValidationCoerce
+)
+
+// SchemaValidationError - a message has failed the validation against the station schema.
+type SchemaValidationError struct {
+	StationName   string
+	SchemaName    string
+	SchemaType    string
+	SchemaVersion int
+	Err           error
+}
+
+func (e *SchemaValidationError) Error() string {
+	return "Schema validation has failed: " + e.Err.Error()
+}
+
+func (e *SchemaValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationFailure - a message which has failed schema validation, Coerced tells whether it was fixed and produced.
+type ValidationFailure struct {
+	Message any
+	Headers Headers
+	Err     *SchemaValidationError
+	Coerced bool
+}
+
+// ValidationFailureHandler - called for every message which fails schema validation.
+type ValidationFailureHandler func(p *Producer, failure ValidationFailure)
+
+func (p *Producer) onValidationFailure(msg any, headers map[string][]string, err *SchemaValidationError, coerced bool) {
+	if p.validationFailureHandler == nil {
+		return
+	}
+	p.validationFailureHandler(p, ValidationFailure{Message: msg, Headers: userHeaders(headers), Err: err, Coerced: coerced})
+}
+
+// coerceMsg - fixes a message to match the schema, returns the encoded message.
+func (sd *schemaDetails) coerceMsg(msg any) ([]byte, error) {
+	switch sd.schemaType {
+	case "protobuf":
+		return sd.coerceProtoMsg(msg)
+	case "json":
+		return sd.coerceJsonMsg(msg)
+	case "avro":
+		return sd.coerceAvroMsg(msg)
+	default:
+		return nil, errors.New("messages of " + sd.schemaType + " schemas can not be coerced")
+	}
+}
+
+func (sd *schemaDetails) coerceProtoMsg(msg any) ([]byte, error) {
+	pMsg := dynamicpb.NewMessage(sd.msgDescriptor)
+	unmarshalOpts := proto.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}
+	switch m := msg.(type) {
+	case protoreflect.ProtoMessage:
+		msgBytes, err := proto.MarshalOptions{AllowPartial: true}.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		if err := unmarshalOpts.Unmarshal(msgBytes, pMsg); err != nil {
+			return nil, err
+		}
+	case []byte:
+		if err := unmarshalOpts.Unmarshal(m, pMsg); err != nil {
+			return nil, err
+		}
+	case map[string]interface{}:
+		msgBytes, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		if err := (protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}).Unmarshal(msgBytes, pMsg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported message type")
+	}
+	fillProtoDefaults(pMsg)
+	return proto.Marshal(pMsg)
+}
+
+// fillProtoDefaults - sets the fields which have a default value and are missing, in nested messages too.
+func fillProtoDefaults(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasDefault() && !m.Has(fd) {
+			m.Set(fd, fd.Default())
+		}
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && m.Has(fd) {
+			fillProtoDefaults(m.Mutable(fd).Message())
+		}
+	}
+}
+
+func (sd *schemaDetails) coerceJsonMsg(msg any) ([]byte, error) {
+	message, err := jsonValue(msg)
+	if err != nil {
+		return nil, err
+	}
+	message = jsonDropUnknown(sd.jsonSchema, message)
+	if err := sd.jsonSchema.Validate(message); err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+// jsonDropUnknown - drops the properties which a schema does not allow, in nested objects and arrays too.
+func jsonDropUnknown(schema *jsonschema.Schema, v any) any {
+	if schema == nil {
+		return v
+	}
+	v = jsonDropUnknown(schema.Ref, v)
+	for _, s := range schema.AllOf {
+		v = jsonDropUnknown(s, v)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		closed := schema.AdditionalProperties == false
+		obj := make(map[string]interface{}, len(val))
+		for k, pv := range val {
+			if ps, ok := schema.Properties[k]; ok {
+				obj[k] = jsonDropUnknown(ps, pv)
+				continue
+			}
+			if jsonMatchesPattern(schema, k) {
+				obj[k] = pv
+				continue
+			}
+			if closed {
+				continue
+			}
+			if as, ok := schema.AdditionalProperties.(*jsonschema.Schema); ok {
+				pv = jsonDropUnknown(as, pv)
+			}
+			obj[k] = pv
+		}
+		return obj
+	case []interface{}:
+		items, _ := schema.Items.(*jsonschema.Schema)
+		if items == nil {
+			items = schema.Items2020
+		}
+		if items == nil {
+			return v
+		}
+		arr := make([]interface{}, len(val))
+		for i, item := range val {
+			arr[i] = jsonDropUnknown(items, item)
+		}
+		return arr
+	}
+	return v
+}
+
+func jsonMatchesPattern(schema *jsonschema.Schema, key string) bool {
+	for re := range schema.PatternProperties {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sd *schemaDetails) coerceAvroMsg(msg any) ([]byte, error) {
+	message, err := jsonValue(msg)
+	if err != nil {
+		return nil, err
+	}
+	message = avroCoerceValue(sd.avroSchema, message)
+	if _, err := avro.Marshal(sd.avroSchema, avroNativeValue(sd.avroSchema, message)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+// avroCoerceValue - sets the defaults of missing record fields and drops unknown fields, in nested values too.
+func avroCoerceValue(schema avro.Schema, v any) any {
+	switch sch := schema.(type) {
+	case *avro.RecordSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		record := make(map[string]interface{}, len(sch.Fields()))
+		for _, field := range sch.Fields() {
+			if val, ok := m[field.Name()]; ok {
+				record[field.Name()] = avroCoerceValue(field.Type(), val)
+			} else if field.HasDefault() {
+				record[field.Name()] = field.Default()
+			}
+		}
+		return record
+	case *avro.ArraySchema:
+		items, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		arr := make([]interface{}, len(items))
+		for i, item := range items {
+			arr[i] = avroCoerceValue(sch.Items(), item)
+		}
+		return arr
+	case *avro.MapSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		values := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			values[k] = avroCoerceValue(sch.Values(), val)
+		}
+		return values
+	case *avro.UnionSchema:
+		if v == nil {
+			return nil
+		}
+		for _, t := range sch.Types() {
+			if t.Type() != avro.Null {
+				return avroCoerceValue(t, v)
+			}
+		}
+	case *avro.RefSchema:
+		return avroCoerceValue(sch.Schema(), v)
+	}
+	return v
+}
+
+// jsonValue - the generic json representation of a message.
+func jsonValue(msg any) (any, error) {
+	var msgBytes []byte
+	switch m := msg.(type) {
+	case []byte:
+		msgBytes = m
+	case map[string]interface{}:
+		return m, nil
+	default:
+		if reflect.TypeOf(msg).Kind() != reflect.Struct {
+			return nil, errors.New("unsupported message type")
+		}
+		var err error
+		if msgBytes, err = json.Marshal(msg); err != nil {
+			return nil, err
+		}
+	}
+	var message any
+	if err := json.Unmarshal(msgBytes, &message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
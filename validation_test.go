@@ -0,0 +1,91 @@
+package memphis
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCoerceJsonMsg(t *testing.T) {
+	sd := schemaDetails{name: "test", schemaType: "json", activeVersion: SchemaVersion{Content: `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"address": {"type": "object", "properties": {"city": {"type": "string"}}, "additionalProperties": false}
+		},
+		"additionalProperties": false
+	}`}}
+	if err := sd.compileJsonSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte(`{"name": "memphis", "unknown": 1, "address": {"city": "tlv", "unknown": 2}}`)
+	if _, err := sd.validateMsg(msg); err == nil {
+		t.Fatal("expected the message to fail validation")
+	}
+	coerced, err := sd.coerceMsg(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(coerced) != `{"address":{"city":"tlv"},"name":"memphis"}` {
+		t.Errorf("unexpected coerced message %s", coerced)
+	}
+
+	if _, err := sd.coerceMsg([]byte(`{"name": 1}`)); err == nil {
+		t.Error("a message with a wrong type should not be coerced")
+	}
+}
+
+func TestCoerceAvroMsg(t *testing.T) {
+	sd := schemaDetails{schemaType: "avro", activeVersion: SchemaVersion{Content: `{
+		"type": "record",
+		"name": "user",
+		"fields": [
+			{"name": "name", "type": "string"},
+			{"name": "age", "type": "int", "default": 18}
+		]
+	}`}}
+	if err := sd.compileAvroSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	coerced, err := sd.coerceMsg([]byte(`{"name": "memphis", "unknown": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message map[string]interface{}
+	if err := json.Unmarshal(coerced, &message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message) != 2 || message["name"] != "memphis" || message["age"] != float64(18) {
+		t.Errorf("unexpected coerced message %s", coerced)
+	}
+
+	if _, err := sd.coerceMsg([]byte(`{"age": 3}`)); err == nil {
+		t.Error("a message missing a field without a default should not be coerced")
+	}
+}
+
+func TestCoerceProtoMsg(t *testing.T) {
+	sd := schemaDetails{schemaType: "protobuf", msgDescriptor: (&descriptorpb.FileOptions{}).ProtoReflect().Descriptor()}
+	msg := map[string]interface{}{"javaPackage": "dev.memphis", "unknown": "field"}
+	if _, err := sd.validateMsg(msg); err == nil {
+		t.Fatal("expected the message to fail validation")
+	}
+	coerced, err := sd.coerceMsg(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fileOpts descriptorpb.FileOptions
+	if err := proto.Unmarshal(coerced, &fileOpts); err != nil {
+		t.Fatal(err)
+	}
+	if fileOpts.GetJavaPackage() != "dev.memphis" {
+		t.Errorf("unexpected java package %v", fileOpts.GetJavaPackage())
+	}
+	if fileOpts.OptimizeFor == nil || *fileOpts.OptimizeFor != descriptorpb.FileOptions_SPEED {
+		t.Errorf("expected the default of optimize_for to be set, got %v", fileOpts.OptimizeFor)
+	}
+}
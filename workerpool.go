@@ -0,0 +1,147 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+
+package memphis
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// OrderingKeyFunc - returns the ordering key of a message, messages with the same key are handled one after the other.
+// Messages with an empty key are not ordered.
+type OrderingKeyFunc func(msg *Msg) string
+
+// OrderByPartitionKey - orders messages by the partition key they were produced with.
+func OrderByPartitionKey() OrderingKeyFunc {
+	return OrderByHeader(partitionKeyHeader)
+}
+
+// OrderByHeader - orders messages by the value of a header.
+func OrderByHeader(key string) OrderingKeyFunc {
+	return func(msg *Msg) string {
+		return msg.natsHeaders().Get(key)
+	}
+}
+
+// workerPool - passes consumed messages to the handler one by one on a bounded number of goroutines.
+// A message holds a slot from its dispatch until its handler returns, so dispatching blocks while all the slots are taken.
+type workerPool struct {
+	handler     ConsumeHandler
+	orderingKey OrderingKeyFunc
+	slots       chan struct{}
+	mu          sync.Mutex
+	keyQueues   map[string][]*Msg
+	inFlight    sync.WaitGroup
+}
+
+func newWorkerPool(handler ConsumeHandler, concurrency int, orderingKey OrderingKeyFunc) *workerPool {
+	return &workerPool{
+		handler:     handler,
+		orderingKey: orderingKey,
+		slots:       make(chan struct{}, concurrency),
+		keyQueues:   make(map[string][]*Msg),
+	}
+}
+
+// consumeHandler - a handler which dispatches the messages to the pool, errors are passed to the handler right away.
+func (wp *workerPool) consumeHandler() ConsumeHandler {
+	return func(msgs []*Msg, err error, ctx context.Context) {
+		if err != nil {
+			wp.handler(nil, err, ctx)
+		}
+		for _, msg := range msgs {
+			wp.dispatch(ctx, msg)
+		}
+	}
+}
+
+func (wp *workerPool) dispatch(ctx context.Context, msg *Msg) {
+	wp.slots <- struct{}{}
+	wp.inFlight.Add(1)
+
+	key := ""
+	if wp.orderingKey != nil {
+		key = wp.orderingKey(msg)
+	}
+	if key != "" {
+		wp.mu.Lock()
+		if queue, ok := wp.keyQueues[key]; ok {
+			// a worker is busy with this key, it takes the message once it is done
+			wp.keyQueues[key] = append(queue, msg)
+			wp.mu.Unlock()
+			return
+		}
+		wp.keyQueues[key] = nil
+		wp.mu.Unlock()
+	}
+	go wp.work(ctx, key, msg)
+}
+
+// work - handles a message, then the messages queued behind it with the same key.
+func (wp *workerPool) work(ctx context.Context, key string, msg *Msg) {
+	for {
+		wp.handle(ctx, msg)
+		if key == "" {
+			wp.inFlight.Done()
+			return
+		}
+		wp.mu.Lock()
+		queue := wp.keyQueues[key]
+		if len(queue) == 0 {
+			delete(wp.keyQueues, key)
+			wp.mu.Unlock()
+			wp.inFlight.Done()
+			return
+		}
+		msg = queue[0]
+		queue[0] = nil
+		wp.keyQueues[key] = queue[1:]
+		wp.mu.Unlock()
+		wp.inFlight.Done()
+	}
+}
+
+func (wp *workerPool) handle(ctx context.Context, msg *Msg) {
+	defer func() { <-wp.slots }()
+	wp.handler([]*Msg{msg}, nil, ctx)
+}
+
+// wait - waits for the handlers of all the dispatched messages to return.
+func (wp *workerPool) wait() {
+	wp.inFlight.Wait()
+}
+
+// Concurrency - max number of messages handled at the same time by Consume, each handler call gets a single message.
+// Defaults to 1, where the handler gets whole batches. Stop waits for the handlers of messages in flight to return,
+// StopConsume does not.
+func Concurrency(n int) ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		if n < 1 {
+			return errors.New("concurrency has to be a positive number")
+		}
+		opts.Concurrency = n
+		return nil
+	}
+}
+
+// OrderingKey - keeps messages with the same key in order while messages with different keys are handled concurrently,
+// use OrderByPartitionKey, OrderByHeader or a function of your own.
+func OrderingKey(orderingKey OrderingKeyFunc) ConsumingOpt {
+	return func(opts *ConsumingOpts) error {
+		opts.OrderingKey = orderingKey
+		return nil
+	}
+}
@@ -0,0 +1,115 @@
+package memphis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestWorkerPoolOrdering(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	var running, maxRunning atomic.Int32
+	handler := func(msgs []*Msg, err error, ctx context.Context) {
+		if len(msgs) != 1 {
+			t.Errorf("expected a single message per call, got %v", len(msgs))
+		}
+		n := running.Add(1)
+		for {
+			max := maxRunning.Load()
+			if n <= max || maxRunning.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+
+		msg := msgs[0]
+		seq, _ := strconv.Atoi(string(msg.Data()))
+		mu.Lock()
+		key := msg.natsHeaders().Get("key")
+		handled[key] = append(handled[key], seq)
+		mu.Unlock()
+	}
+
+	wp := newWorkerPool(handler, 4, OrderByHeader("key"))
+	consume := wp.consumeHandler()
+	var msgs []*Msg
+	for i := 0; i < 40; i++ {
+		header := nats.Header{"key": []string{strconv.Itoa(i % 3)}}
+		msgs = append(msgs, &Msg{msg: &nats.Msg{Data: []byte(strconv.Itoa(i)), Header: header}})
+	}
+	consume(msgs[:20], nil, nil)
+	consume(msgs[20:], nil, nil)
+	wp.wait()
+
+	total := 0
+	for key, seqs := range handled {
+		total += len(seqs)
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Errorf("messages with key %v were handled out of order: %v", key, seqs)
+				break
+			}
+		}
+	}
+	if total != 40 {
+		t.Errorf("expected 40 handled messages, got %v", total)
+	}
+	if maxRunning.Load() > 3 {
+		t.Errorf("expected at most one handler per key, got %v running", maxRunning.Load())
+	}
+	if len(wp.keyQueues) != 0 {
+		t.Errorf("expected no queued keys, got %v", len(wp.keyQueues))
+	}
+}
+
+func TestConsumeConcurrencyStopWaits(t *testing.T) {
+	tc := &testStreamingJsConsumer{}
+	c := &Consumer{
+		stationName:   "test_station",
+		jsConsumers:   map[int]jetstream.Consumer{1: tc},
+		expiredPolicy: ExpiredDeliver,
+		consumeMode:   ConsumeStreaming,
+	}
+	c.subscriptionActive.Store(true)
+	if err := Concurrency(0)(&ConsumingOpts{}); err == nil {
+		t.Error("expected error for zero concurrency")
+	}
+
+	release := make(chan struct{})
+	var done atomic.Int32
+	err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		<-release
+		done.Add(1)
+	}, Concurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.handler(&testJsMsg{data: []byte("a")})
+	tc.handler(&testJsMsg{data: []byte("b")})
+
+	stopped := make(chan struct{})
+	go func() {
+		if err := c.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("expected Stop to wait for the handlers in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	if done.Load() != 2 {
+		t.Errorf("expected 2 handled messages, got %v", done.Load())
+	}
+}